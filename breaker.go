@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// circuitBreakerConfig configures the circuit breaker around influxdb writes
+type circuitBreakerConfig struct {
+	// Threshold is the number of consecutive failed writes opening the circuit, zero disables it
+	Threshold int
+	// Cooldown is how long writes are stopped once the circuit opens
+	Cooldown time.Duration
+	// Drop drops points while the circuit is open instead of keeping them in the clients retry buffer
+	Drop bool
+}
+
+// breakerState is the state of a circuitBreaker
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+var errCircuitOpen = errors.New("circuit breaker is open, not writing to influxdb")
+
+var (
+	breakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "deflux_influx_circuit_breaker_state",
+		Help: "State of the circuit breaker around influxdb writes, 0 closed, 1 open and 2 half-open.",
+	})
+	breakerDroppedPoints = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "deflux_influx_circuit_breaker_dropped_points_total",
+		Help: "Number of points dropped while the circuit breaker was open.",
+	})
+)
+
+// circuitBreaker stops writes to influxdb after consecutive failures, once the cooldown
+// has passed a single write is let through to probe if influxdb has recovered
+type circuitBreaker struct {
+	config circuitBreakerConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// newCircuitBreaker returns a circuit breaker, or nil if the configuration disables it
+func newCircuitBreaker(c circuitBreakerConfig) *circuitBreaker {
+	if c.Threshold <= 0 {
+		return nil
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = 30 * time.Second
+	}
+	return &circuitBreaker{config: c, now: time.Now}
+}
+
+// allow reports if a write should be attempted, moving an open circuit to half-open
+// once the cooldown has passed, the write allowed then is the probe
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.config.Cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		logging.Infof("Circuit breaker cooldown passed, probing influxdb")
+		return true
+	case breakerHalfOpen:
+		// the probe is still in flight
+		return false
+	default:
+		return true
+	}
+}
+
+// dropping reports if points should be dropped, which is while the circuit is open
+// and the cooldown has not passed or while the probe is in flight
+func (b *circuitBreaker) dropping() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return b.now().Sub(b.openedAt) < b.config.Cooldown
+	case breakerHalfOpen:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		logging.Infof("Influxdb write succeeded, closing circuit breaker")
+	}
+	b.failures = 0
+	b.setState(breakerClosed)
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.config.Threshold {
+		if b.state != breakerOpen {
+			logging.Warnf("Influxdb write failed %d times in a row, stopping writes for %s", b.failures, b.config.Cooldown)
+		}
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(s breakerState) {
+	b.state = s
+	breakerStateGauge.Set(float64(s))
+}
+
+// breakerTransport rejects requests while the circuit is open and reports
+// the outcome of the requests it lets through
+type breakerTransport struct {
+	http.RoundTripper
+	breaker *circuitBreaker
+}
+
+// RoundTrip passes the request on if the breaker allows it
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		t.breaker.failure()
+	} else {
+		t.breaker.success()
+	}
+	return resp, err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	breaker := newCircuitBreaker(circuitBreakerConfig{Threshold: 2, Cooldown: time.Minute})
+	breaker.now = func() time.Time { return now }
+	client := http.Client{Transport: &breakerTransport{RoundTripper: http.DefaultTransport, breaker: breaker}}
+
+	write := func() error {
+		resp, err := client.Post(server.URL+"/api/v2/write", "text/plain", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// two failures opens the circuit
+	write()
+	write()
+	if breaker.state != breakerOpen {
+		t.Fatalf("expected open circuit, got %s", breaker.state)
+	}
+	if !breaker.dropping() {
+		t.Error("expected points to be dropped while open")
+	}
+
+	// while open no requests reach influxdb
+	if err := write(); err == nil || requests != 2 {
+		t.Errorf("expected request to be rejected, got %v after %d requests", err, requests)
+	}
+
+	// after the cooldown a failed probe opens the circuit again
+	now = now.Add(time.Minute)
+	if breaker.dropping() {
+		t.Error("expected points to be let through once the cooldown passed")
+	}
+	write()
+	if requests != 3 || breaker.state != breakerOpen {
+		t.Errorf("expected failed probe to reopen circuit, got %s after %d requests", breaker.state, requests)
+	}
+
+	// a successful probe closes it
+	now = now.Add(time.Minute)
+	status = http.StatusNoContent
+	write()
+	if requests != 4 || breaker.state != breakerClosed {
+		t.Errorf("expected successful probe to close circuit, got %s after %d requests", breaker.state, requests)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	if newCircuitBreaker(circuitBreakerConfig{}) != nil {
+		t.Error("expected no breaker without a threshold")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// chattyConfig finds sensors sending more events than expected
+type chattyConfig struct {
+	// MaxRate is the events per second a sensor may send on average over a
+	// window before a warning is logged, zero disables the warnings
+	MaxRate float64
+	// Window is the period rates are measured over, defaults to a minute
+	Window time.Duration
+	// Top is the number of chattiest sensors reported on /status, defaults to 5
+	Top int
+}
+
+const (
+	defaultChattyWindow = time.Minute
+	defaultChattyTop    = 5
+)
+
+// sensorRate is the rate of events a sensor sent in the last window
+type sensorRate struct {
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	EventsPerSecond float64 `json:"events_per_second"`
+}
+
+// chattyTracker measures the rate of events per sensor in fixed windows
+type chattyTracker struct {
+	config chattyConfig
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+	names       map[string]string
+	// rates of the last complete window, chattiest first
+	rates []sensorRate
+}
+
+var chatty = newChattyTracker(chattyConfig{})
+
+var _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "deflux_chattiest_sensor_events_per_second",
+	Help: "Events per second sent by the chattiest sensor in the last window.",
+}, func() float64 { return chatty.max() })
+
+func newChattyTracker(c chattyConfig) *chattyTracker {
+	if c.Window <= 0 {
+		c.Window = defaultChattyWindow
+	}
+	if c.Top <= 0 {
+		c.Top = defaultChattyTop
+	}
+	return &chattyTracker{config: c, counts: make(map[string]int), names: make(map[string]string)}
+}
+
+// event records an event of sensor id, as returned by SensorID, received at
+func (c *chattyTracker) event(id string, name string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.windowStart.IsZero() {
+		c.windowStart = at
+	}
+	if elapsed := at.Sub(c.windowStart); elapsed >= c.config.Window {
+		c.rollover(elapsed)
+		c.windowStart = at
+	}
+
+	c.counts[id]++
+	c.names[id] = name
+}
+
+// rollover computes the rates of the window ending after elapsed and warns
+// about sensors exceeding MaxRate, c.mu must be held
+func (c *chattyTracker) rollover(elapsed time.Duration) {
+	c.rates = c.rates[:0]
+	for id, n := range c.counts {
+		c.rates = append(c.rates, sensorRate{ID: id, Name: c.names[id], EventsPerSecond: float64(n) / elapsed.Seconds()})
+	}
+	sort.Slice(c.rates, func(i, j int) bool {
+		if c.rates[i].EventsPerSecond != c.rates[j].EventsPerSecond {
+			return c.rates[i].EventsPerSecond > c.rates[j].EventsPerSecond
+		}
+		return c.rates[i].ID < c.rates[j].ID
+	})
+
+	if c.config.MaxRate > 0 {
+		for _, r := range c.rates {
+			if r.EventsPerSecond <= c.config.MaxRate {
+				break
+			}
+			logging.Warnf("sensor %s (%s) sent %.2f events per second over the last %s, exceeding %.2f, it may need debouncing", r.Name, r.ID, r.EventsPerSecond, elapsed.Round(time.Second), c.config.MaxRate)
+		}
+	}
+
+	c.counts = make(map[string]int, len(c.counts))
+	c.names = make(map[string]string, len(c.names))
+}
+
+// top returns the chattiest sensors of the last complete window
+func (c *chattyTracker) top() []sensorRate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.rates)
+	if n > c.config.Top {
+		n = c.config.Top
+	}
+	return append([]sensorRate(nil), c.rates[:n]...)
+}
+
+// max returns the rate of the chattiest sensor of the last complete window
+func (c *chattyTracker) max() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.rates) == 0 {
+		return 0
+	}
+	return c.rates[0].EventsPerSecond
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChattyTracker(t *testing.T) {
+	c := newChattyTracker(chattyConfig{MaxRate: 1, Window: 10 * time.Second, Top: 2})
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// 20 events from the door sensor, 5 from the kitchen and 1 from the hallway
+	for i := 0; i < 20; i++ {
+		c.event("3", "Door", start.Add(time.Duration(i)*500*time.Millisecond))
+	}
+	for i := 0; i < 5; i++ {
+		c.event("5", "Kitchen", start.Add(time.Duration(i)*time.Second))
+	}
+	c.event("7", "Hallway", start)
+
+	if top := c.top(); len(top) != 0 {
+		t.Errorf("expected no rates before the window ended, got %v", top)
+	}
+
+	// the window ends with the first event after it
+	c.event("5", "Kitchen", start.Add(10*time.Second))
+
+	top := c.top()
+	if len(top) != 2 {
+		t.Logf("expected the top 2 sensors, got %v", top)
+		t.FailNow()
+	}
+	if top[0] != (sensorRate{ID: "3", Name: "Door", EventsPerSecond: 2}) || top[1] != (sensorRate{ID: "5", Name: "Kitchen", EventsPerSecond: 0.5}) {
+		t.Errorf("unexpected top sensors %v", top)
+	}
+	if c.max() != 2 {
+		t.Errorf("expected a max rate of 2, got %f", c.max())
+	}
+
+	// the next window only holds the kitchen event ending the previous one
+	c.event("3", "Door", start.Add(20*time.Second))
+	if top := c.top(); len(top) != 1 || top[0].ID != "5" || top[0].EventsPerSecond != 0.1 {
+		t.Errorf("unexpected top sensors %v", top)
+	}
+}
+
+func TestChattyTrackerDefaults(t *testing.T) {
+	c := newChattyTracker(chattyConfig{})
+	if c.config.Window != defaultChattyWindow || c.config.Top != defaultChattyTop {
+		t.Errorf("unexpected defaults %+v", c.config)
+	}
+	if c.max() != 0 {
+		t.Errorf("expected no rate without events, got %f", c.max())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// commands are run by giving their name as the first argument, e.g. deflux types
+var commands = map[string]func(args []string) error{
+	"doctor":         doctorCommand,
+	"export-sensors": exportSensorsCommand,
+	"gen-config":     genConfigCommand,
+	"sample":         sampleCommand,
+	"types":          typesCommand,
+	"watch":          watchCommand,
+}
+
+// runCommand runs the command named by args[0]
+func runCommand(args []string) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+	return command(args[1:])
+}
+
+// genConfigCommand prints a configuration from discovery and pairing, or
+// writes it to --output, whether or not a configuration exists already
+func genConfigCommand(args []string) error {
+	flags := flag.NewFlagSet("gen-config", flag.ContinueOnError)
+	output := flags.String("output", "", "file to write the configuration to instead of stdout")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return generateConfiguration(os.Stdout)
+	}
+
+	var b bytes.Buffer
+	err = generateConfiguration(&b)
+	if err != nil {
+		return err
+	}
+	// the configuration holds the api key, keep it to ourselves
+	err = ioutil.WriteFile(*output, b.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	logging.Infof("Wrote configuration to %s", *output)
+	return nil
+}
+
+// typesCommand prints the sensor types deflux maps and their fields
+func typesCommand(args []string) error {
+	return writeTypes(os.Stdout)
+}
+
+func writeTypes(w io.Writer) error {
+	for _, st := range event.SensorTypes() {
+		_, err := fmt.Fprintf(w, "%s (%s)\n", st.Type, measurementName(st.Type))
+		if err != nil {
+			return err
+		}
+
+		fields := make([]string, 0, len(st.Fields))
+		for field := range st.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fmt.Fprintf(w, "  %s %s\n", field, st.Fields[field])
+		}
+	}
+
+	_, err := fmt.Fprintln(w, "\nEvery sensor type also writes battery int and reachable bool when the sensor reports them.")
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestWriteTypes(t *testing.T) {
+	var b bytes.Buffer
+	err := writeTypes(&b)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	if !strings.Contains(b.String(), "ZHATemperature (deflux_ZHATemperature)\n  temperature float64\n") {
+		t.Errorf("expected ZHATemperature in types, got %s", b.String())
+	}
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	if err := runCommand([]string{"unknown"}); err == nil {
+		t.Error("expected an error running an unknown command")
+	}
+}
+
+func TestGenConfigCommand(t *testing.T) {
+	*noDiscoverFlag, *noPairFlag = true, true
+	defer func() { *noDiscoverFlag, *noPairFlag = false, false }()
+
+	output := filepath.Join(t.TempDir(), "deflux.yml")
+	err := runCommand([]string{"gen-config", "--output", output})
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the configuration to be readable only by us, got %s", info.Mode())
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	var config Configuration
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if config.Deconz.Addr != "http://127.0.0.1:8080/" {
+		t.Errorf("expected the default address, got %s", config.Deconz.Addr)
+	}
+}
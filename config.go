@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/logging"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	noDiscoverFlag = flag.Bool("no-discover", false, "never discover gateways, the sample configuration keeps the default address")
+	discoveryFlag  = flag.String("discovery", "", "discovery mode used for the sample configuration: local, cloud or off (default local)")
+	noPairFlag     = flag.Bool("no-pair", false, "never pair with the gateway, the sample configuration needs the api key filled in")
+)
+
+// YmlFileName is the filename
+const YmlFileName = "deflux.yml"
+
+var (
+	configFlag        = flag.String("config", "", "configuration file or http(s) url to use, - reads it from stdin (default ./deflux.yml or /etc/deflux.yml)")
+	configTokenFlag   = flag.String("config-token", os.Getenv("DEFLUX_CONFIG_TOKEN"), "bearer token sent when fetching the configuration from a url (default $DEFLUX_CONFIG_TOKEN)")
+	configTimeoutFlag = flag.Duration("config-timeout", 10*time.Second, "timeout fetching the configuration from a url")
+	configCacheFlag   = flag.String("config-cache", defaultConfigCache(), "file caching the configuration fetched from a url, used if fetching fails")
+	deconzConfigFlag  = flag.String("deconz-config", "", "yaml file holding the deconz section of the configuration")
+	influxConfigFlag  = flag.String("influx-config", "", "yaml file holding the influxdb2 section of the configuration")
+)
+
+// defaultConfigCache returns the default location of the cached configuration
+func defaultConfigCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "deflux", YmlFileName)
+}
+
+// Configuration holds data for Deconz and influxdb configuration
+type Configuration struct {
+	Deconz    deconz.Config        `yaml:"deconz"`
+	Influxdb2 influxdb2ConfigProxy `yaml:"influxdb2"`
+	Metrics   metricsConfig        `yaml:"metrics"`
+	Status    statusConfig         `yaml:"status"`
+	// LogLevel is one of debug, info, warn or error, defaults to info
+	LogLevel string `yaml:"loglevel"`
+	// DedupeWindow suppresses events repeating the last written fields of their
+	// sensor within the window, zero disables it
+	DedupeWindow time.Duration `yaml:"dedupewindow"`
+	// Discovery toggles the methods used to discover gateways when
+	// generating a configuration
+	Discovery deconz.DiscoveryOptions `yaml:"discovery"`
+	// Sentinels omits fields reporting one of the listed values instead of a reading
+	Sentinels sentinels `yaml:"sentinels"`
+	// Deltas derives delta fields from monotonic counters
+	Deltas deltaConfig `yaml:"deltas"`
+	// MaxSilence exits deflux once no events were received for the duration,
+	// zero disables it
+	MaxSilence time.Duration `yaml:"maxsilence"`
+	// SystemdNotify notifies systemd once deflux is ready and pings its
+	// watchdog, it does nothing unless started by systemd
+	SystemdNotify bool `yaml:"systemdnotify"`
+	// Keyring reads secrets from the keyring of the OS
+	Keyring keyringConfig `yaml:"keyring"`
+	// Chatty warns about sensors sending too many events
+	Chatty chattyConfig `yaml:"chatty"`
+	// FieldMapping is a yaml file renaming fields before they are written
+	FieldMapping string `yaml:"fieldmapping"`
+	// ShutdownTimeout bounds how long pending points are flushed when shutting
+	// down, defaults to defaultShutdownTimeout
+	ShutdownTimeout time.Duration `yaml:"shutdowntimeout"`
+	// StartupDelay is waited before connecting to the gateway and influxdb
+	StartupDelay time.Duration `yaml:"startupdelay"`
+	// RemoteWrite also writes the points to a prometheus remote write endpoint
+	RemoteWrite remoteWriteConfig `yaml:"remotewrite"`
+	// Tap re-broadcasts the raw frames of deconz on a websocket
+	Tap tapConfig `yaml:"tap"`
+}
+
+// configurationKey normalizes a top level key of the configuration, so
+// Deconz, log_level and log-level are read as deconz and loglevel
+func configurationKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+}
+
+// rawSection holds a section of the configuration until the field it is
+// decoded into is known
+type rawSection struct {
+	unmarshal func(interface{}) error
+}
+
+func (s *rawSection) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	s.unmarshal = unmarshal
+	return nil
+}
+
+// UnmarshalYAML reads the configuration accepting its top level keys in any
+// casing, the keys within each section are left as they are
+func (c *Configuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Configuration
+
+	var sections yaml.MapSlice
+	err := unmarshal(&sections)
+	if err != nil {
+		return err
+	}
+
+	renamed := false
+	seen := make(map[string]bool, len(sections))
+	for _, section := range sections {
+		key, ok := section.Key.(string)
+		if !ok {
+			continue
+		}
+		normalized := configurationKey(key)
+		if seen[normalized] {
+			return fmt.Errorf("%s is configured more than once", normalized)
+		}
+		seen[normalized] = true
+		renamed = renamed || normalized != key
+	}
+	err = unmarshal((*plain)(c))
+	if err != nil || !renamed {
+		return err
+	}
+
+	// renamed sections are decoded from the original yaml, decoding the
+	// values of sections again would turn apikey: 0123456789 into a number
+	var raw map[string]rawSection
+	err = unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]reflect.Value)
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		fields[tag] = v.Field(i)
+	}
+	for key, section := range raw {
+		field, ok := fields[configurationKey(key)]
+		if !ok || configurationKey(key) == key {
+			continue
+		}
+		err = section.unmarshal(field.Addr().Interface())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadConfiguration(name string) (*Configuration, error) {
+	data, err := readConfiguration(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration: %s", err)
+	}
+
+	var config Configuration
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %s", err)
+	}
+	return &config, nil
+}
+
+// loadFlagConfiguration loads the configuration named by --config composed
+// with --deconz-config and --influx-config, which may hold all of it
+func loadFlagConfiguration() (*Configuration, error) {
+	config, err := loadConfiguration(*configFlag)
+	if err != nil && *configFlag == "" && (*deconzConfigFlag != "" || *influxConfigFlag != "") {
+		config, err = &Configuration{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = composeConfiguration(config, *deconzConfigFlag, *influxConfigFlag)
+	if err != nil {
+		return nil, err
+	}
+	applyDefaults(config)
+	applyKeyring(config)
+	// rediscovering the gateway uses the same methods as discovering it
+	config.Deconz.Discovery = config.Discovery
+	redactSecrets(config)
+	return config, nil
+}
+
+// redactSecrets keeps the credentials of config out of logs and traces, header
+// values are redacted as they often hold the credentials of a proxy
+func redactSecrets(config *Configuration) {
+	logging.Redact(config.Deconz.APIKey)
+	logging.Redact(config.Influxdb2.Token)
+	for _, value := range config.Influxdb2.Headers {
+		logging.Redact(value)
+	}
+	logging.Redact(config.RemoteWrite.Password)
+	logging.Redact(config.RemoteWrite.BearerToken)
+	for _, value := range config.RemoteWrite.Headers {
+		logging.Redact(value)
+	}
+}
+
+// defaults filled in for settings missing from a configuration
+const (
+	defaultBatchSize     = 20
+	defaultFlushInterval = time.Second
+	defaultWorkers       = 1
+)
+
+// applyDefaults fills the settings of config left out, or set to zero, with
+// their defaults and logs each default applied
+func applyDefaults(config *Configuration) {
+	if config.Influxdb2.BatchSize == 0 {
+		logging.Infof("no influxdb2 batchsize configured, using the default of %d", defaultBatchSize)
+		config.Influxdb2.BatchSize = defaultBatchSize
+	}
+	if config.Influxdb2.FlushInterval == 0 {
+		logging.Infof("no influxdb2 flushinterval configured, using the default of %s", defaultFlushInterval)
+		config.Influxdb2.FlushInterval = defaultFlushInterval
+	}
+	if config.Influxdb2.Workers == 0 {
+		logging.Infof("no influxdb2 workers configured, using the default of %d", defaultWorkers)
+		config.Influxdb2.Workers = defaultWorkers
+	}
+}
+
+// composeConfiguration reads the deconz and influxdb2 sections of config from
+// the files deconzName and influxName, empty names are skipped. Settings in
+// the files override the ones already in config
+func composeConfiguration(config *Configuration, deconzName, influxName string) error {
+	if deconzName != "" {
+		err := readSection(deconzName, &config.Deconz)
+		if err != nil {
+			return fmt.Errorf("could not load deconz configuration: %s", err)
+		}
+	}
+	if influxName != "" {
+		err := readSection(influxName, &config.Influxdb2)
+		if err != nil {
+			return fmt.Errorf("could not load influxdb2 configuration: %s", err)
+		}
+	}
+	return nil
+}
+
+// readSection unmarshals the yaml file name into section
+func readSection(name string, section interface{}) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, section)
+}
+
+// readConfiguration reads the configuration file name, stdin if name is "-" or
+// searches for a configuration if no name is given
+func readConfiguration(name string) ([]byte, error) {
+	switch {
+	case name == "":
+		return searchConfiguration()
+	case name == "-":
+		return readStdinConfiguration(os.Stdin)
+	case strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://"):
+		return fetchConfiguration(name, *configTokenFlag, *configTimeoutFlag, *configCacheFlag)
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Infof("Using configuration %s", name)
+	return data, nil
+}
+
+// readStdinConfiguration reads the configuration piped to deflux
+func readStdinConfiguration(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read stdin: %s", err)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errors.New("no configuration was given on stdin")
+	}
+
+	logging.Infof("Using configuration from stdin")
+	return data, nil
+}
+
+// fetchConfiguration fetches the configuration from url, caching it on disk, if fetching fails
+// the cached copy from the last successful fetch is used instead
+func fetchConfiguration(url, token string, timeout time.Duration, cache string) ([]byte, error) {
+	data, err := httpConfiguration(url, token, timeout)
+	if err == nil {
+		logging.Infof("Using configuration %s", url)
+		if cache != "" {
+			cacheErr := writeConfigCache(cache, data)
+			if cacheErr != nil {
+				logging.Warnf("unable to cache configuration: %s", cacheErr)
+			}
+		}
+		return data, nil
+	}
+
+	if cache == "" {
+		return nil, err
+	}
+
+	cached, cacheErr := ioutil.ReadFile(cache)
+	if cacheErr != nil {
+		return nil, fmt.Errorf("%s\nno cached configuration: %s", err, cacheErr)
+	}
+
+	logging.Warnf("unable to fetch configuration: %s, using cached configuration %s", err, cache)
+	return cached, nil
+}
+
+func httpConfiguration(url, token string, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %s", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected statuscode from %s: %d", url, resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration from %s: %s", url, err)
+	}
+	return data, nil
+}
+
+// writeConfigCache writes the configuration readable only by us as it contains secrets
+func writeConfigCache(cache string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(cache), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cache, data, 0600)
+}
+
+// searchConfiguration tries to read pwd/deflux.yml or /etc/deflux.yml
+func searchConfiguration() ([]byte, error) {
+	// first try to load ${pwd}/deflux.yml
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current work directory: %s", err)
+	}
+
+	pwdPath := path.Join(pwd, YmlFileName)
+	data, pwdErr := ioutil.ReadFile(pwdPath)
+	if pwdErr == nil {
+		logging.Infof("Using configuration %s", pwdPath)
+		return data, nil
+	}
+
+	// if we reached this code, we where unable to read a "local" Configuration
+	// try from /etc/deflux.yml
+	etcPath := path.Join("/etc", YmlFileName)
+	data, etcErr := ioutil.ReadFile(etcPath)
+	if etcErr != nil {
+		return nil, fmt.Errorf("\n%s\n%s", pwdErr, etcErr)
+	}
+
+	logging.Infof("Using configuration %s", etcPath)
+	return data, nil
+}
+
+func outputDefaultConfiguration() {
+	logging.Infof("Outputting default configuration, save this to /etc/deflux.yml")
+	err := generateConfiguration(os.Stdout)
+	if err != nil {
+		log.Fatalf("unable to generate default configuration: %s", err)
+	}
+}
+
+// generateConfiguration writes the default configuration to w, with the
+// discovered gateway and the api key from pairing filled in
+func generateConfiguration(w io.Writer) error {
+	c := defaultConfiguration()
+
+	// try to pair with deconz
+	u, err := url.Parse(c.Deconz.Addr)
+	if err == nil && !*noPairFlag {
+		apikey, err := deconz.Pair(*u)
+		if err != nil {
+			logging.Warnf("unable to pair with deconz: %s, please fill out APIKey manually", err)
+		}
+		c.Deconz.APIKey = string(apikey)
+	}
+
+	yml, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(yml)
+	return err
+}
+
+func defaultConfiguration() *Configuration {
+	enabled := true
+
+	// this is the default configuration
+	c := Configuration{
+		Deconz: deconz.Config{
+			Addr:   "http://127.0.0.1:8080/",
+			APIKey: "change me",
+		},
+		Influxdb2: influxdb2ConfigProxy{
+			Enabled:       &enabled,
+			URL:           "http://127.0.0.1:8086/",
+			Org:           "change me",
+			Token:         "change me",
+			Bucket:        "change me",
+			BatchSize:     defaultBatchSize,
+			FlushInterval: defaultFlushInterval,
+			Workers:       defaultWorkers,
+		},
+		LogLevel:  logging.InfoLevel.String(),
+		Discovery: deconz.DiscoveryOptions{Mode: *discoveryFlag},
+	}
+
+	if *noDiscoverFlag {
+		return &c
+	}
+
+	// lets see if we are able to discover a gateway, and overwrite parts of the
+	// default congfiguration
+	discovered, err := deconz.DiscoverWith(c.Discovery)
+	if err != nil {
+		logging.Warnf("discovery of deconz gateway failed: %s, please fill configuration manually..", err)
+		return &c
+	}
+
+	// TODO: discover is actually a slice of multiple discovered gateways,
+	// but for now we use only the first available. Discovery already removed
+	// the gateways found by more than one method, so the others are distinct
+	deconz := discovered[0]
+	for _, other := range discovered[1:] {
+		logging.Infof("also discovered gateway %s at %s:%d, change addr to use it instead", other.Name, other.InternalIPAddress, other.InternalPort)
+	}
+	addr := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", deconz.InternalIPAddress, deconz.InternalPort),
+		Path:   "/api",
+	}
+	c.Deconz.Addr = addr.String()
+
+	return &c
+}
+
+// validateConfiguration returns the first problem of config deflux refuses to
+// start with, it is checked both when starting and by the doctor command
+func validateConfiguration(config *Configuration) error {
+	err := config.Deconz.Timeseries.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid deconz timeseries options: %s", err)
+	}
+	err = deconz.ValidateFullPolicy(config.Deconz.FullPolicy, config.Deconz.EventBuffer)
+	if err != nil {
+		return fmt.Errorf("invalid deconz fullpolicy: %s", err)
+	}
+	err = validateHeaders(config.Influxdb2.Headers)
+	if err != nil {
+		return fmt.Errorf("invalid influxdb2 headers: %s", err)
+	}
+	if config.MaxSilence > 0 && config.MaxSilence < minMaxSilence {
+		return fmt.Errorf("invalid maxsilence %s, must be at least %s", config.MaxSilence, minMaxSilence)
+	}
+	if config.RemoteWrite.URL != "" {
+		err = config.RemoteWrite.validate()
+		if err != nil {
+			return fmt.Errorf("invalid remote write configuration: %s", err)
+		}
+	}
+	if config.Influxdb2.Precision != 0 && !validPrecision(config.Influxdb2.Precision) {
+		return fmt.Errorf("invalid influxdb2 precision %s, must be one of 1ns, 1us, 1ms or 1s", config.Influxdb2.Precision)
+	}
+	err = config.Influxdb2.Precisions.validate()
+	if err != nil {
+		return fmt.Errorf("invalid influxdb2 precisions: %s", err)
+	}
+	timezone, err := loadTimezone(config.Influxdb2.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid influxdb2 timezone: %s", err)
+	}
+	// lastupdatedtimezone reads the instants the gateway reports correctly,
+	// timezone would shift them again
+	if timezone != nil && config.Deconz.Timeseries.LastUpdatedTimezone != "" {
+		return fmt.Errorf("influxdb2 timezone shifts the instants of points, it can not be combined with the lastupdatedtimezone of deconz")
+	}
+	_, err = newMeasurementTemplate(config.Influxdb2.MeasurementTemplate, config.Influxdb2.Rooms)
+	if err != nil {
+		return fmt.Errorf("invalid measurement template: %s", err)
+	}
+	_, err = newDeltas(config.Deltas)
+	if err != nil {
+		return fmt.Errorf("invalid deltas: %s", err)
+	}
+	// workers take events in no particular order, so consecutive events of a
+	// sensor could be subtracted the wrong way around
+	if len(config.Deltas.Fields) > 0 && config.Influxdb2.enabled() && config.Influxdb2.Workers > 1 {
+		return fmt.Errorf("deltas need the events of a sensor in order, they can not be combined with %d influxdb2 workers", config.Influxdb2.Workers)
+	}
+	if config.FieldMapping != "" {
+		_, err = loadFieldMapping(config.FieldMapping)
+		if err != nil {
+			return fmt.Errorf("unable to load field mapping: %s", err)
+		}
+	}
+	if config.Influxdb2.TokenFile != "" {
+		_, err = readToken(config.Influxdb2.TokenFile)
+		if err != nil {
+			return fmt.Errorf("unable to read influxdb2 token: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestReadStdinConfiguration(t *testing.T) {
+	data, err := readStdinConfiguration(strings.NewReader("deconz:\n  addr: http://127.0.0.1:8080/api\n"))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	var config Configuration
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if config.Deconz.Addr != "http://127.0.0.1:8080/api" {
+		t.Errorf("unexpected addr %s", config.Deconz.Addr)
+	}
+}
+
+func TestReadStdinConfigurationEmpty(t *testing.T) {
+	_, err := readStdinConfiguration(strings.NewReader(" \n"))
+	if err == nil {
+		t.Error("expected an error on empty stdin")
+	}
+}
+
+func TestFetchConfiguration(t *testing.T) {
+	const configuration = "deconz:\n  addr: http://127.0.0.1:8080/api\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(configuration))
+	}))
+	cache := filepath.Join(t.TempDir(), "deflux", "deflux.yml")
+
+	_, err := fetchConfiguration(server.URL, "wrong", time.Second, "")
+	if err == nil {
+		t.Error("expected an error with the wrong token")
+	}
+
+	data, err := fetchConfiguration(server.URL, "secret", time.Second, cache)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if string(data) != configuration {
+		t.Errorf("unexpected configuration %q", data)
+	}
+
+	// once the server is gone the cached copy is used
+	server.Close()
+	data, err = fetchConfiguration(server.URL, "secret", time.Second, cache)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if string(data) != configuration {
+		t.Errorf("unexpected cached configuration %q", data)
+	}
+
+	_, err = fetchConfiguration(server.URL, "secret", time.Second, filepath.Join(t.TempDir(), "missing.yml"))
+	if err == nil {
+		t.Error("expected an error without server or cache")
+	}
+}
+
+func TestComposeConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	deconzName := filepath.Join(dir, "deconz.yml")
+	influxName := filepath.Join(dir, "influx.yml")
+	err := ioutil.WriteFile(deconzName, []byte("addr: http://10.0.0.2:8080/api\napikey: secret\n"), 0600)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	err = ioutil.WriteFile(influxName, []byte("url: http://10.0.0.3:8086\nbucket: sensors\n"), 0600)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	config := Configuration{LogLevel: "debug"}
+	config.Deconz.APIKeyHeader = "X-Key"
+	config.Influxdb2.Bucket = "deconz"
+	err = composeConfiguration(&config, deconzName, influxName)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	if config.Deconz.Addr != "http://10.0.0.2:8080/api" || config.Deconz.APIKey != "secret" {
+		t.Errorf("unexpected deconz configuration %+v", config.Deconz)
+	}
+	if config.Deconz.APIKeyHeader != "X-Key" {
+		t.Errorf("expected settings missing from the deconz file to be kept, got %q", config.Deconz.APIKeyHeader)
+	}
+	if config.Influxdb2.URL != "http://10.0.0.3:8086" || config.Influxdb2.Bucket != "sensors" {
+		t.Errorf("unexpected influxdb2 configuration %+v", config.Influxdb2)
+	}
+	if config.LogLevel != "debug" {
+		t.Errorf("unexpected loglevel %s", config.LogLevel)
+	}
+
+	err = composeConfiguration(&config, filepath.Join(dir, "missing.yml"), "")
+	if err == nil {
+		t.Error("expected an error on a missing deconz file")
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	var config Configuration
+	err := yaml.Unmarshal([]byte("influxdb2:\n  url: http://10.0.0.3:8086\n  workers: 4\n"), &config)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	applyDefaults(&config)
+	if config.Influxdb2.BatchSize != defaultBatchSize || config.Influxdb2.FlushInterval != defaultFlushInterval {
+		t.Errorf("expected the default batch size and flush interval, got %d and %s", config.Influxdb2.BatchSize, config.Influxdb2.FlushInterval)
+	}
+	if config.Influxdb2.Workers != 4 {
+		t.Errorf("expected the configured workers to be kept, got %d", config.Influxdb2.Workers)
+	}
+}
+
+func TestConfigurationKeyCasing(t *testing.T) {
+	for _, data := range []string{
+		"deconz:\n  addr: http://10.0.0.2/api\nloglevel: debug\ninfluxdb2:\n  rooms:\n    Kitchen Sensor: Kitchen\n",
+		"Deconz:\n  addr: http://10.0.0.2/api\nLogLevel: debug\nInfluxdb2:\n  rooms:\n    Kitchen Sensor: Kitchen\n",
+		"DECONZ:\n  addr: http://10.0.0.2/api\nlog_level: debug\ninfluxdb2:\n  rooms:\n    Kitchen Sensor: Kitchen\n",
+	} {
+		var config Configuration
+		err := yaml.Unmarshal([]byte(data), &config)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", data, err)
+			continue
+		}
+		if config.Deconz.Addr != "http://10.0.0.2/api" || config.LogLevel != "debug" {
+			t.Errorf("%q: unexpected configuration %+v", data, config)
+		}
+		// keys within sections keep their casing
+		if config.Influxdb2.Rooms["Kitchen Sensor"] != "Kitchen" {
+			t.Errorf("%q: unexpected rooms %v", data, config.Influxdb2.Rooms)
+		}
+	}
+
+	var config Configuration
+	if err := yaml.Unmarshal([]byte("deconz:\n  addr: a\nDeconz:\n  addr: b\n"), &config); err == nil {
+		t.Error("expected an error for a section configured twice")
+	}
+}
+
+func TestConfigurationKeyCasingScalars(t *testing.T) {
+	// values of renamed sections are read like the ones of lowercase sections
+	for _, data := range []string{
+		"deconz:\n  apikey: 0123456789\ninfluxdb2:\n  token: 1e3\n",
+		"Deconz:\n  apikey: 0123456789\nInfluxdb2:\n  token: 1e3\n",
+	} {
+		var config Configuration
+		err := yaml.Unmarshal([]byte(data), &config)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		if config.Deconz.APIKey != "0123456789" || config.Influxdb2.Token != "1e3" {
+			t.Errorf("%q: expected apikey 0123456789 and token 1e3, got %q and %q", data, config.Deconz.APIKey, config.Influxdb2.Token)
+		}
+	}
+}
+
+func TestValidateConfiguration(t *testing.T) {
+	for _, c := range []struct {
+		config Configuration
+		valid  bool
+	}{
+		{Configuration{}, true},
+		{Configuration{MaxSilence: 2 * time.Hour}, true},
+		{Configuration{MaxSilence: 5 * time.Nanosecond}, false},
+		{Configuration{Influxdb2: influxdb2ConfigProxy{Timezone: "Europe/Berlin"}}, true},
+		{Configuration{Deconz: deconz.Config{Timeseries: deconz.TimeseriesOptions{LastUpdatedTimezone: "Europe/Berlin"}}}, true},
+		{Configuration{Influxdb2: influxdb2ConfigProxy{Timezone: "Europe/Berlin"}, Deconz: deconz.Config{Timeseries: deconz.TimeseriesOptions{LastUpdatedTimezone: "Europe/Berlin"}}}, false},
+		{Configuration{Influxdb2: influxdb2ConfigProxy{BatchSize: 20, Precision: time.Minute}}, false},
+		{Configuration{Influxdb2: influxdb2ConfigProxy{BatchSize: 20}, RemoteWrite: remoteWriteConfig{URL: "http://prometheus:9090/api/v1/write"}}, true},
+		{Configuration{Influxdb2: influxdb2ConfigProxy{BatchSize: 20}, RemoteWrite: remoteWriteConfig{URL: "prometheus:9090"}}, false},
+		{Configuration{Influxdb2: influxdb2ConfigProxy{Workers: 1}, Deltas: deltaConfig{Fields: []string{"consumption"}}}, true},
+		{Configuration{Influxdb2: influxdb2ConfigProxy{Workers: 4}, Deltas: deltaConfig{Fields: []string{"consumption"}}}, false},
+	} {
+		err := validateConfiguration(&c.config)
+		if (err == nil) != c.valid {
+			t.Errorf("%+v: expected valid %t, got %v", c.config, c.valid, err)
+		}
+	}
+}
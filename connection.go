@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// connectionPool tunes the connections kept open to influxdb
+type connectionPool struct {
+	// MaxIdleConns bounds the idle connections kept open, defaults to 100
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept open to influxdb,
+	// defaults to 10
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes connections idle for longer, defaults to 90s
+	IdleConnTimeout time.Duration
+	// KeepAlive is the interval of tcp keepalive probes, defaults to 30s,
+	// negative disables them
+	KeepAlive time.Duration
+	// DisableKeepAlives opens a new connection for every request
+	DisableKeepAlives bool
+}
+
+// defaults of connectionPool
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+)
+
+// transport returns a transport pooling connections as configured by c
+func (c connectionPool) transport() *http.Transport {
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = defaultMaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = defaultKeepAlive
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: c.KeepAlive,
+	}).DialContext
+	transport.MaxIdleConns = c.MaxIdleConns
+	transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = c.IdleConnTimeout
+	transport.DisableKeepAlives = c.DisableKeepAlives
+	return transport
+}
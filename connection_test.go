@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnectionPoolDefaults(t *testing.T) {
+	transport := connectionPool{}.transport()
+	if transport.MaxIdleConns != 100 || transport.MaxIdleConnsPerHost != 10 || transport.IdleConnTimeout != 90*time.Second {
+		t.Errorf("expected the default pool, got %d idle, %d per host and a timeout of %s",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	transport = connectionPool{MaxIdleConns: 4, MaxIdleConnsPerHost: 2, IdleConnTimeout: time.Minute, DisableKeepAlives: true}.transport()
+	if transport.MaxIdleConns != 4 || transport.MaxIdleConnsPerHost != 2 || transport.IdleConnTimeout != time.Minute || !transport.DisableKeepAlives {
+		t.Errorf("expected the configured pool, got %d idle, %d per host, a timeout of %s and keepalives disabled %t",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, transport.DisableKeepAlives)
+	}
+}
+
+func TestConnectionPoolReuse(t *testing.T) {
+	for _, c := range []struct {
+		pool        connectionPool
+		connections int
+	}{
+		{connectionPool{}, 1},
+		{connectionPool{DisableKeepAlives: true}, 3},
+	} {
+		var mu sync.Mutex
+		connections := 0
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				mu.Lock()
+				connections++
+				mu.Unlock()
+			}
+		}
+		server.Start()
+
+		client := &http.Client{Transport: c.pool.transport()}
+		for i := 0; i < 3; i++ {
+			resp, err := client.Post(server.URL+"/api/v2/write", "text/plain", nil)
+			if err != nil {
+				t.Logf(err.Error())
+				t.FailNow()
+			}
+			resp.Body.Close()
+		}
+		server.Close()
+
+		mu.Lock()
+		if connections != c.connections {
+			t.Errorf("%+v: expected %d connections, got %d", c.pool, c.connections, connections)
+		}
+		mu.Unlock()
+	}
+}
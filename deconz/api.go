@@ -2,10 +2,14 @@ package deconz
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
 )
 
 // API represents the deCONZ rest api
@@ -14,17 +18,62 @@ type API struct {
 	sensorCache *CachedSensorStore
 }
 
+// ErrUnauthorized is returned by Check when the gateway rejects the api key
+var ErrUnauthorized = errors.New("the gateway rejected the api key")
+
+// Check requests the rest api once to verify the gateway is reachable and
+// accepts the api key, ErrUnauthorized is returned if it does not
+func (a *API) Check() error {
+	resp, err := a.Config.get("sensors")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	}
+	return fmt.Errorf("unexpected statuscode from deconz: %d", resp.StatusCode)
+}
+
+// GatewayVersion returns the firmware version of the gateway
+func (a *API) GatewayVersion() (string, error) {
+	resp, err := a.Config.get("config")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected statuscode from deconz: %d", resp.StatusCode)
+	}
+
+	var conf config
+	err = json.NewDecoder(resp.Body).Decode(&conf)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode gateway config: %s", err)
+	}
+	return conf.Swversion, nil
+}
+
 // Sensors returns a map of sensors
 func (a *API) Sensors() (*Sensors, error) {
 
-	url := fmt.Sprintf("%s/%s/sensors", a.Config.Addr, a.Config.APIKey)
-	resp, err := http.Get(url)
+	resp, err := a.Config.get("sensors")
 	if err != nil {
-		return nil, fmt.Errorf("unable to get %s: %s", url, err)
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected statuscode from deconz: %d", resp.StatusCode)
+	}
+
 	var sensors Sensors
 
 	dec := json.NewDecoder(resp.Body)
@@ -37,29 +86,139 @@ func (a *API) Sensors() (*Sensors, error) {
 
 }
 
+// SensorStates returns the raw state of every sensor indexed by their id
+func (a *API) SensorStates() (map[int]json.RawMessage, error) {
+
+	resp, err := a.Config.get("sensors")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected statuscode from deconz: %d", resp.StatusCode)
+	}
+
+	var sensors map[int]struct {
+		State json.RawMessage
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	err = dec.Decode(&sensors)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode deCONZ response: %s", err)
+	}
+
+	states := make(map[int]json.RawMessage, len(sensors))
+	for id, sensor := range sensors {
+		states[id] = sensor.State
+	}
+
+	return states, nil
+}
+
+// RawSensors returns every sensor indexed by their id with all the fields
+// reported by the gateway, numbers are kept as json.Number
+func (a *API) RawSensors() (map[int]map[string]interface{}, error) {
+
+	resp, err := a.Config.get("sensors")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected statuscode from deconz: %d", resp.StatusCode)
+	}
+
+	var sensors map[int]map[string]interface{}
+
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	err = dec.Decode(&sensors)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode deCONZ response: %s", err)
+	}
+
+	return sensors, nil
+}
+
 // EventReader returns a event.Reader with a default cached type store
 func (a *API) EventReader() (*event.Reader, error) {
 
 	if a.sensorCache == nil {
-		a.sensorCache = &CachedSensorStore{SensorGetter: a}
+		a.sensorCache = &CachedSensorStore{SensorGetter: a, RefreshInterval: a.Config.SensorRefreshInterval, Reconnect: a.Config.Reconnect}
 	}
 
 	if a.Config.wsAddr == "" {
-		err := a.Config.discoverWebsocket()
+		err := a.Config.Reconnect.retry("discovering the websocket", a.Config.discoverWebsocket)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return &event.Reader{TypeStore: a.sensorCache, WebsocketAddr: a.Config.wsAddr}, nil
+	header := a.Config.header()
+	if a.Config.WebsocketOrigin != "" {
+		header.Set("Origin", a.Config.WebsocketOrigin)
+	}
+
+	return &event.Reader{
+		TypeStore:     a.sensorCache,
+		WebsocketAddr: a.Config.wsAddr,
+		Header:        header,
+		Subprotocols:  a.Config.WebsocketSubprotocols,
+		DialTimeout:   a.Config.dialTimeout(),
+
+		ReadBufferSize:  a.Config.WebsocketReadBufferSize,
+		WriteBufferSize: a.Config.WebsocketWriteBufferSize,
+	}, nil
+}
+
+// PollingReader returns a PollingReader polling at the configured interval, clamped to the
+// configured minimum interval
+func (a *API) PollingReader() *PollingReader {
+
+	if a.sensorCache == nil {
+		a.sensorCache = &CachedSensorStore{SensorGetter: a, RefreshInterval: a.Config.SensorRefreshInterval, Reconnect: a.Config.Reconnect}
+	}
+
+	return &PollingReader{
+		Interval:    pollInterval(a.Config.PollInterval, a.Config.MinPollInterval),
+		TypeStore:   a.sensorCache,
+		StateGetter: a,
+	}
 }
 
 // SensorEventReader takes an event reader and returns an sensor event reader
-func (a *API) SensorEventReader(r *event.Reader) *SensorEventReader {
+func (a *API) SensorEventReader(r EventReader) *SensorEventReader {
 
 	if a.sensorCache == nil {
-		a.sensorCache = &CachedSensorStore{SensorGetter: a}
+		a.sensorCache = &CachedSensorStore{SensorGetter: a, RefreshInterval: a.Config.SensorRefreshInterval, Reconnect: a.Config.Reconnect}
 	}
 
-	return &SensorEventReader{lookup: a.sensorCache, reader: r}
+	reader := &SensorEventReader{
+		lookup:               a.sensorCache,
+		reader:               r,
+		options:              &a.Config.Timeseries,
+		maxReconnectsPerHour: a.Config.MaxReconnectsPerHour,
+		reconnect:            a.Config.Reconnect,
+		resources:            a.Config.Resources,
+		fullPolicy:           a.Config.FullPolicy,
+	}
+	if a.Config.Timeseries.GatewaySWVersionTag {
+		reader.gatewayVersion = a.GatewayVersion
+	}
+	if a.Config.SnapshotOnStart {
+		reader.snapshot = a.SensorStates
+	}
+	if er, ok := r.(*event.Reader); ok && a.Config.Reconnect.RediscoverAfter > 0 {
+		if a.Config.Discovery.Mode == DiscoveryOff {
+			logging.Warnf("not rediscovering the gateway, discovery is off")
+		} else {
+			reader.rediscover = func() error { return a.rediscover(er) }
+		}
+	}
+	return reader
 }
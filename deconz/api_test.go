@@ -0,0 +1,106 @@
+package deconz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dfuchslin/deflux/internal/deconztest"
+)
+
+const sensorsResponse = `{"5":{"name":"Test Sensor","type":"ZHAFire","swversion":"20170627","config":{"on":true},"state":{"fire":false,"lastupdated":"2018-03-13T19:46:03"}}}`
+
+func TestSensorsAPIKey(t *testing.T) {
+	var path, header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		header = r.Header.Get("X-Api-Key")
+		w.Write([]byte(sensorsResponse))
+	}))
+	defer server.Close()
+
+	// by default the api key is part of the path
+	a := API{Config: Config{Addr: server.URL + "/api", APIKey: "secret"}}
+	sensors, err := a.Sensors()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if path != "/api/secret/sensors" || header != "" {
+		t.Errorf("expected api key in path, got path %s and header %s", path, header)
+	}
+	if (*sensors)[5].SWVersion != "20170627" {
+		t.Errorf("unexpected sensors %v", *sensors)
+	}
+
+	// or sent as a header
+	a = API{Config: Config{Addr: server.URL + "/api", APIKey: "secret", APIKeyHeader: "X-Api-Key"}}
+	_, err = a.Sensors()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if path != "/api/sensors" || header != "secret" {
+		t.Errorf("expected api key in header, got path %s and header %s", path, header)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(sensorsResponse))
+	}))
+	defer server.Close()
+
+	a := API{Config: Config{Addr: server.URL + "/api", APIKey: "secret"}}
+	_, err := a.Sensors()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if userAgent != DefaultUserAgent {
+		t.Errorf("expected user agent %s, got %s", DefaultUserAgent, userAgent)
+	}
+
+	a = API{Config: Config{Addr: server.URL + "/api", APIKey: "secret", UserAgent: "custom/1.0", wsAddr: "ws://gateway/"}}
+	_, err = a.Sensors()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if userAgent != "custom/1.0" {
+		t.Errorf("expected user agent custom/1.0, got %s", userAgent)
+	}
+
+	// the websocket handshake carries the same user agent
+	r, err := a.EventReader()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if r.Header.Get("User-Agent") != "custom/1.0" {
+		t.Errorf("expected websocket user agent custom/1.0, got %s", r.Header.Get("User-Agent"))
+	}
+}
+
+func TestGatewayVersion(t *testing.T) {
+	gateway := deconztest.NewGateway("secret", "{}")
+	gateway.SWVersion = "2.11.05"
+	defer gateway.Close()
+
+	a := API{Config: Config{Addr: gateway.Addr(), APIKey: "secret"}}
+	version, err := a.GatewayVersion()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if version != "2.11.05" {
+		t.Errorf("unexpected gateway version %q", version)
+	}
+
+	a.Config.APIKey = "wrong"
+	if _, err := a.GatewayVersion(); err == nil {
+		t.Error("expected an error with the wrong api key")
+	}
+}
@@ -3,14 +3,23 @@ package deconz
 import (
 	"errors"
 	"fmt"
-	"log"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
 )
 
 // CachedSensorStore is a cached typestore which provides LookupType for event passing
 // it will be our default store
 type CachedSensorStore struct {
 	SensorGetter
-	cache *Sensors
+	// RefreshInterval refetches the sensors once it passed, so renamed sensors
+	// are picked up, zero only fetches them again for unknown ids
+	RefreshInterval time.Duration
+	// Reconnect retries the first fetch of the sensors, so a gateway that is
+	// still booting is waited for
+	Reconnect   ReconnectOptions
+	cache       map[int]*Sensor
+	populatedAt time.Time
 }
 
 // SensorGetter defines how we like to ask for sensors
@@ -29,8 +38,9 @@ func (c *CachedSensorStore) LookupType(i int) (string, error) {
 			return "", fmt.Errorf("unable to populate sensors: %s", err)
 		}
 	}
+	c.refresh(time.Now())
 
-	if s, found := (*c.cache)[i]; found {
+	if s, found := c.cache[i]; found {
 		return s.Type, nil
 	}
 
@@ -46,9 +56,10 @@ func (c *CachedSensorStore) LookupSensor(i int) (*Sensor, error) {
 			return nil, fmt.Errorf("unable to populate sensors: %s", err)
 		}
 	}
+	c.refresh(time.Now())
 
-	if s, found := (*c.cache)[i]; found {
-		return &s, nil
+	if s, found := c.cache[i]; found {
+		return s, nil
 	}
 
 	// try repopulating cache once to see if any new sensors have arrived
@@ -57,21 +68,56 @@ func (c *CachedSensorStore) LookupSensor(i int) (*Sensor, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to populate sensors: %s", err)
 	}
-	if s, found := (*c.cache)[i]; found {
-		return &s, nil
+	if s, found := c.cache[i]; found {
+		return s, nil
 	}
 
 	return nil, errors.New("no such sensor")
 }
 
+// refresh repopulates the cache once RefreshInterval passed, a failure keeps
+// the cached sensors until the next interval
+func (c *CachedSensorStore) refresh(now time.Time) {
+	if c.RefreshInterval <= 0 || now.Sub(c.populatedAt) < c.RefreshInterval {
+		return
+	}
+	err := c.populateCache()
+	if err != nil {
+		c.populatedAt = now
+		logging.Warnf("unable to refresh sensors, keeping the cached ones: %s", err)
+	}
+}
+
+// populateCache fetches the sensors, the first fetch is retried as configured
+// by Reconnect while refreshes keep the cached sensors if they fail
 func (c *CachedSensorStore) populateCache() error {
+	var sensors *Sensors
+	fetch := func() error {
+		var err error
+		sensors, err = c.Sensors()
+		return err
+	}
 	var err error
-	c.cache, err = c.Sensors()
+	if c.cache == nil {
+		err = c.Reconnect.retry("fetching sensors", fetch)
+	} else {
+		err = fetch()
+	}
 	if err != nil {
 		return err
 	}
 
-	log.Printf("SensorStore updated, found %d sensors", len((*c.cache)))
+	// keep pointers to the sensors so tags are computed once per sensor
+	// instead of once per event
+	c.cache = make(map[int]*Sensor, len(*sensors))
+	for id, s := range *sensors {
+		s := s
+		s.tags = s.timeseriesTags(id)
+		c.cache[id] = &s
+	}
+	c.populatedAt = time.Now()
+
+	logging.Infof("SensorStore updated, found %d sensors", len(c.cache))
 
 	return nil
 }
@@ -0,0 +1,79 @@
+package deconz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// renamingSensorGetter returns the next name on every fetch, failing once out of names
+type renamingSensorGetter struct {
+	names []string
+}
+
+func (r *renamingSensorGetter) Sensors() (*Sensors, error) {
+	if len(r.names) == 0 {
+		return nil, errors.New("gateway unreachable")
+	}
+	name := r.names[0]
+	r.names = r.names[1:]
+	return &Sensors{5: Sensor{Name: name, Type: "ZHAFire"}}, nil
+}
+
+func TestCachedSensorStoreRefresh(t *testing.T) {
+	getter := &renamingSensorGetter{names: []string{"Hallway", "Kitchen"}}
+	store := CachedSensorStore{SensorGetter: getter, RefreshInterval: time.Hour}
+
+	sensor, err := store.LookupSensor(5)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if sensor.Name != "Hallway" {
+		t.Errorf("expected Hallway, got %s", sensor.Name)
+	}
+
+	// nothing is fetched within the interval
+	store.refresh(time.Now())
+	if sensor, _ := store.LookupSensor(5); sensor.Name != "Hallway" {
+		t.Errorf("expected the cached name within the interval, got %s", sensor.Name)
+	}
+
+	store.refresh(time.Now().Add(2 * time.Hour))
+	if sensor, _ := store.LookupSensor(5); sensor.Name != "Kitchen" || sensor.tags["name"] != "Kitchen" {
+		t.Errorf("expected the renamed sensor, got %s tagged %v", sensor.Name, sensor.tags)
+	}
+
+	// failing keeps the cached sensors
+	store.refresh(time.Now().Add(4 * time.Hour))
+	if sensor, _ := store.LookupSensor(5); sensor.Name != "Kitchen" {
+		t.Errorf("expected the cached sensor after a failed refresh, got %s", sensor.Name)
+	}
+}
+
+func TestCachedSensorStoreRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "booting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(sensorsResponse))
+	}))
+	defer server.Close()
+
+	// a gateway answering with an error is not cached as a gateway without sensors
+	a := &API{Config: Config{Addr: server.URL + "/api", APIKey: "secret"}}
+	store := CachedSensorStore{SensorGetter: a, Reconnect: ReconnectOptions{Attempts: 2, Delay: time.Millisecond}}
+	sensor, err := store.LookupSensor(5)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if requests != 2 || sensor.SWVersion != "20170627" {
+		t.Errorf("expected the sensors of the second request, got %d requests and %+v", requests, sensor)
+	}
+}
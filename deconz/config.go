@@ -6,18 +6,142 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
 )
 
+// DefaultDialTimeout is used when connecting to the websocket unless a Config sets DialTimeout
+const DefaultDialTimeout = 10 * time.Second
+
+// DefaultUserAgent is sent to the gateway unless a Config sets its own UserAgent
+var DefaultUserAgent = "deflux"
+
+// httpClient makes every request to deCONZ, tracing them at the trace level
+var httpClient = &http.Client{Transport: &logging.TraceTransport{RoundTripper: http.DefaultTransport}}
+
 // Config represents a Deconz gateway
 type Config struct {
 	Addr   string
 	APIKey string
-	wsAddr string
+	// APIKeyHeader sends the api key in this header instead of the url path
+	// for proxies injecting the key themselves
+	APIKeyHeader string
+	// UserAgent is sent with every request to the gateway, defaults to DefaultUserAgent
+	UserAgent string
+	// WebsocketOrigin is sent as the Origin header of the websocket handshake
+	WebsocketOrigin string
+	// WebsocketSubprotocols are requested during the websocket handshake
+	WebsocketSubprotocols []string
+	// WebsocketReadBufferSize and WebsocketWriteBufferSize are the buffer
+	// sizes of the websocket in bytes, zero uses the default of 4096
+	WebsocketReadBufferSize  int
+	WebsocketWriteBufferSize int
+	// Timeseries configures the tags and fields of the events written
+	Timeseries TimeseriesOptions
+	// PollInterval enables polling the rest api for sensor changes instead of
+	// using the websocket, zero means websocket
+	PollInterval time.Duration
+	// MinPollInterval is the lowest PollInterval allowed, defaults to DefaultMinPollInterval
+	MinPollInterval time.Duration
+	// MaxReconnectsPerHour logs a warning when reconnecting more often, zero disables the warning
+	MaxReconnectsPerHour int
+	// DialTimeout bounds how long connecting to the websocket may take before
+	// it is retried, defaults to DefaultDialTimeout
+	DialTimeout time.Duration
+	// Resources lists the websocket event resources forwarded, e.g. sensors,
+	// lights, groups or scenes, defaults to sensors
+	Resources []string
+	// Reconnect configures retrying the gateway at startup and after losing the websocket
+	Reconnect ReconnectOptions
+	// SnapshotOnStart sends an event with the current state of every sensor
+	// once connected, so dashboards need not wait for the next change
+	SnapshotOnStart bool
+	// SensorRefreshInterval refetches the sensors periodically to pick up
+	// renamed sensors, zero disables it
+	SensorRefreshInterval time.Duration
+	// Discovery is the top level discovery configuration, it is used when
+	// rediscovering the gateway
+	Discovery DiscoveryOptions `yaml:"-"`
+	// EventBuffer is the number of events buffered for slow writers
+	EventBuffer int
+	// FullPolicy is what happens to an event when the buffer is full, one of
+	// PolicyBlock (default), PolicyDropNewest or PolicyDropOldest
+	FullPolicy string
+	wsAddr     string
+	// bridgeID identifies the gateway when rediscovering it
+	bridgeID string
 }
 
 // config is used to parse the things we need from the deCONZ config endpoint
 type config struct {
 	Websocketport int
+	Swversion     string
+	Bridgeid      string
+}
+
+// endpointURL returns the url of a rest api endpoint, the api key is part
+// of the path unless it is sent in a header
+func (c *Config) endpointURL(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.APIKeyHeader == "" {
+		u.Path = path.Join(u.Path, c.APIKey, endpoint)
+	} else {
+		u.Path = path.Join(u.Path, endpoint)
+	}
+	return u, nil
+}
+
+// dialTimeout returns DialTimeout or its default
+func (c *Config) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+	return DefaultDialTimeout
+}
+
+// pathPrefix returns the path a gateway behind a reverse proxy is served
+// below, it is the path of Addr without the trailing /api
+func pathPrefix(p string) string {
+	return strings.TrimSuffix(strings.TrimRight(p, "/"), "/api")
+}
+
+// header returns the headers sent with every request to deCONZ
+func (c *Config) header() http.Header {
+	h := http.Header{}
+	h.Set("User-Agent", DefaultUserAgent)
+	if c.UserAgent != "" {
+		h.Set("User-Agent", c.UserAgent)
+	}
+	if c.APIKeyHeader != "" {
+		h.Set(c.APIKeyHeader, c.APIKey)
+	}
+	return h
+}
+
+// get requests a rest api endpoint
+func (c *Config) get(endpoint string) (*http.Response, error) {
+	u, err := c.endpointURL(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", c.Addr, err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.header()
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get %s: %s", u, err)
+	}
+	return resp, nil
 }
 
 func (c *Config) discoverWebsocket() error {
@@ -25,9 +149,8 @@ func (c *Config) discoverWebsocket() error {
 	if err != nil {
 		return fmt.Errorf("unable to discover websocket: %s", err)
 	}
-	u.Path = path.Join(u.Path, c.APIKey, "config")
 
-	resp, err := http.Get(u.String())
+	resp, err := c.get("config")
 	if err != nil {
 		return fmt.Errorf("unable to discover websocket: %s", err)
 	}
@@ -42,10 +165,12 @@ func (c *Config) discoverWebsocket() error {
 	}
 
 	// change our old parsed url to websocket, it should connect to the websocket endpoint of deCONZ
+	// below the same path prefix as the rest api
 	u.Scheme = "ws"
-	u.Path = "/"
+	u.Path = pathPrefix(u.Path) + "/"
 	u.Host = fmt.Sprintf("%s:%d", u.Hostname(), conf.Websocketport)
 
 	c.wsAddr = u.String()
+	c.bridgeID = conf.Bridgeid
 	return nil
 }
@@ -0,0 +1,75 @@
+package deconz
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPathPrefix(t *testing.T) {
+	for p, expected := range map[string]string{
+		"":            "",
+		"/":           "",
+		"/api":        "",
+		"/api/":       "",
+		"/deconz/api": "/deconz",
+		"/deconz/":    "/deconz",
+	} {
+		if prefix := pathPrefix(p); prefix != expected {
+			t.Errorf("expected prefix %q for %q, got %q", expected, p, prefix)
+		}
+	}
+}
+
+func TestPrefixedAddr(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/deconz/api":
+			w.Write([]byte(`[{"success":{"username":"secret"}}]`))
+		case "/deconz/api/secret/config":
+			w.Write([]byte(`{"websocketport":8443}`))
+		case "/deconz/api/secret/sensors":
+			w.Write([]byte(sensorsResponse))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := Config{Addr: server.URL + "/deconz/api", APIKey: "secret"}
+
+	u, _ := url.Parse(c.Addr)
+	_, err := Pair(*u)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	a := API{Config: c}
+	_, err = a.Sensors()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	err = a.Config.discoverWebsocket()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	expected := fmt.Sprintf("ws://%s:8443/deconz/", u.Hostname())
+	if a.Config.wsAddr != expected {
+		t.Errorf("expected websocket %s, got %s", expected, a.Config.wsAddr)
+	}
+
+	requested := strings.Join(paths, ", ")
+	if requested != "POST /deconz/api, GET /deconz/api/secret/sensors, GET /deconz/api/secret/config" {
+		t.Errorf("unexpected requests %s", requested)
+	}
+}
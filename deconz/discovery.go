@@ -3,34 +3,144 @@ package deconz
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/dfuchslin/deflux/logging"
 )
 
 // DeconzDiscoveryEndpoint is the url used when auto discovering a deconz gateway
 const DeconzDiscoveryEndpoint = "https://dresden-light.appspot.com/discover"
 
-// DiscoveryResponse is a slice of discovered gateways
-type DiscoveryResponse []Discovery
-
-// Discovery is a discovered deconz gateway
+// Gateway is a discovered deconz gateway
 // [{"macaddress": "00212EFFFF017FBD", "name": "deCONZ-GW", "internalipaddress": "192.168.1.90", "publicipaddress": "85.191.222.130", "internalport": 8080, "id": "00212EFFFF017FBD"}]
-type Discovery struct {
-	ID                string
-	Name              string
-	MacAddress        string
-	PublicIPAddress   string
-	InternalIPAddress string
-	InternalPort      uint
-}
-
-// Discover discovers deconz gateways
-func Discover() (DiscoveryResponse, error) {
-	response, err := http.Get(DeconzDiscoveryEndpoint)
+type Gateway struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	InternalIPAddress string `json:"internalipaddress"`
+	InternalPort      uint   `json:"internalport"`
+	MACAddress        string `json:"macaddress"`
+	PublicIPAddress   string `json:"publicipaddress"`
+}
+
+// Discovery modes, DiscoveryLocal is the default
+const (
+	// DiscoveryLocal only searches the local network, nothing leaves it
+	DiscoveryLocal = "local"
+	// DiscoveryCloud also asks the discovery service of dresden elektronik,
+	// which sees the public ip address of the network
+	DiscoveryCloud = "cloud"
+	// DiscoveryOff never discovers gateways
+	DiscoveryOff = "off"
+)
+
+// DiscoveryOptions toggles the methods used to discover gateways
+type DiscoveryOptions struct {
+	// Mode is one of local, cloud or off, defaults to local
+	Mode string
+	// DisableCloud skips asking the discovery service of dresden elektronik
+	DisableCloud bool
+	// DisableUPnP skips searching the local network with UPnP
+	DisableUPnP bool
+}
+
+// methods returns whether the cloud service and the UPnP search are enabled
+func (o DiscoveryOptions) methods() (cloud bool, upnp bool, err error) {
+	switch o.Mode {
+	case "", DiscoveryLocal:
+		return false, !o.DisableUPnP, nil
+	case DiscoveryCloud:
+		return !o.DisableCloud, !o.DisableUPnP, nil
+	case DiscoveryOff:
+		return false, false, nil
+	}
+	return false, false, fmt.Errorf("unknown discovery mode %q, use %s, %s or %s", o.Mode, DiscoveryLocal, DiscoveryCloud, DiscoveryOff)
+}
+
+// Discover discovers deconz gateways on the local network
+func Discover() ([]Gateway, error) {
+	return DiscoverWith(DiscoveryOptions{})
+}
+
+// DiscoverWith discovers deconz gateways using the enabled methods, gateways
+// found by multiple methods are only returned once
+func DiscoverWith(o DiscoveryOptions) ([]Gateway, error) {
+	cloud, upnp, err := o.methods()
+	if err != nil {
+		return nil, err
+	}
+
+	var gateways []Gateway
+	var errs []string
+
+	if cloud {
+		found, err := discover(DeconzDiscoveryEndpoint)
+		if err != nil {
+			logging.Debugf("cloud discovery failed: %s", err)
+			errs = append(errs, err.Error())
+		}
+		gateways = append(gateways, found...)
+	}
+
+	if upnp {
+		found, err := discoverUPnP(DefaultUPnPTimeout)
+		if err != nil {
+			logging.Debugf("upnp discovery failed: %s", err)
+			errs = append(errs, err.Error())
+		}
+		gateways = append(gateways, found...)
+	}
+
+	gateways = dedupeGateways(gateways)
+	if len(gateways) == 0 {
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("every discovery method is disabled")
+		}
+		return nil, fmt.Errorf("no gateways was found: %s", strings.Join(errs, ", "))
+	}
+
+	return gateways, nil
+}
+
+// dedupeGateways removes gateways with the same bridge id or address,
+// keeping the first. Gateways without an id are told apart by their address
+func dedupeGateways(gateways []Gateway) []Gateway {
+	seenIDs := make(map[string]bool, len(gateways))
+	seenAddrs := make(map[string]bool, len(gateways))
+	result := gateways[:0]
+	for _, g := range gateways {
+		id := strings.ToUpper(g.ID)
+		addr := g.addr()
+		if (id != "" && seenIDs[id]) || (addr != "" && seenAddrs[addr]) {
+			continue
+		}
+		if id != "" {
+			seenIDs[id] = true
+		}
+		if addr != "" {
+			seenAddrs[addr] = true
+		}
+		result = append(result, g)
+	}
+	return result
+}
+
+// addr returns the internal address of g, empty if it is unknown
+func (g Gateway) addr() string {
+	if g.InternalIPAddress == "" {
+		return ""
+	}
+	return net.JoinHostPort(g.InternalIPAddress, strconv.Itoa(int(g.InternalPort)))
+}
+
+func discover(endpoint string) ([]Gateway, error) {
+	response, err := httpClient.Get(endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("unable to talk to discovery endpoint: %s", err)
 	}
 
-	var data DiscoveryResponse
+	var data []Gateway
 
 	d := json.NewDecoder(response.Body)
 	defer response.Body.Close()
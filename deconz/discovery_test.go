@@ -0,0 +1,130 @@
+package deconz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const discoveryResponse = `[{"macaddress": "00212EFFFF017FBD", "name": "deCONZ-GW", "internalipaddress": "192.168.1.90", "publicipaddress": "85.191.222.130", "internalport": 8080, "id": "00212EFFFF017FBD"}]`
+
+func TestDiscover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(discoveryResponse))
+	}))
+	defer server.Close()
+
+	gateways, err := discover(server.URL)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	expected := Gateway{
+		ID:                "00212EFFFF017FBD",
+		Name:              "deCONZ-GW",
+		InternalIPAddress: "192.168.1.90",
+		InternalPort:      8080,
+		MACAddress:        "00212EFFFF017FBD",
+		PublicIPAddress:   "85.191.222.130",
+	}
+	if len(gateways) != 1 || gateways[0] != expected {
+		t.Errorf("unexpected gateways %+v", gateways)
+	}
+}
+
+const ssdpResponse = "HTTP/1.1 200 OK\r\n" +
+	"CACHE-CONTROL: max-age=100\r\n" +
+	"EXT:\r\n" +
+	"LOCATION: http://192.168.1.90:8080/description.xml\r\n" +
+	"SERVER: FreeRTOS/7.4.2, UPnP/1.0, IpBridge/1.16.0\r\n" +
+	"hue-bridgeid: 00212EFFFF017FBD\r\n" +
+	"ST: urn:schemas-upnp-org:device:basic:1\r\n" +
+	"USN: uuid:2f402f80-da50-11e1-9b23-00212effff017fbd\r\n\r\n"
+
+func TestParseSSDPResponse(t *testing.T) {
+	gateway, ok := parseSSDPResponse([]byte(ssdpResponse))
+	if !ok {
+		t.Fatal("expected a deCONZ gateway")
+	}
+	if gateway.ID != "00212EFFFF017FBD" || gateway.InternalIPAddress != "192.168.1.90" || gateway.InternalPort != 8080 {
+		t.Errorf("unexpected gateway %+v", gateway)
+	}
+
+	// a hue bridge is not a deCONZ gateway
+	hue := strings.Replace(ssdpResponse, "00212EFFFF017FBD", "001788FFFE123456", 1)
+	if _, ok := parseSSDPResponse([]byte(hue)); ok {
+		t.Error("expected other bridges to be ignored")
+	}
+}
+
+func TestDedupeGateways(t *testing.T) {
+	gateways := dedupeGateways([]Gateway{
+		{ID: "00212EFFFF017FBD", Name: "deCONZ-GW"},
+		{ID: "00212EFFFF000001"},
+		{ID: "00212effff017fbd", InternalIPAddress: "192.168.1.90"},
+	})
+	if len(gateways) != 2 || gateways[0].Name != "deCONZ-GW" || gateways[1].ID != "00212EFFFF000001" {
+		t.Errorf("unexpected gateways %+v", gateways)
+	}
+}
+
+func TestDedupeOverlappingGateways(t *testing.T) {
+	// the cloud and upnp both find the gateway at .90, upnp without an id
+	gateways := dedupeGateways([]Gateway{
+		{ID: "00212EFFFF017FBD", InternalIPAddress: "192.168.1.90", InternalPort: 80},
+		{ID: "", InternalIPAddress: "192.168.1.91", InternalPort: 80},
+		{ID: "", InternalIPAddress: "192.168.1.90", InternalPort: 80},
+		{ID: "", InternalIPAddress: "192.168.1.91", InternalPort: 8080},
+		{ID: "00212EFFFF017FBD", InternalIPAddress: "192.168.1.90", InternalPort: 80},
+	})
+	if len(gateways) != 3 {
+		t.Errorf("expected 3 gateways, got %+v", gateways)
+	}
+	for i, expected := range []string{"192.168.1.90:80", "192.168.1.91:80", "192.168.1.91:8080"} {
+		if i < len(gateways) && gateways[i].addr() != expected {
+			t.Errorf("expected gateway %d at %s, got %s", i, expected, gateways[i].addr())
+		}
+	}
+}
+
+func TestDiscoverWithEverythingDisabled(t *testing.T) {
+	_, err := DiscoverWith(DiscoveryOptions{DisableCloud: true, DisableUPnP: true})
+	if err == nil {
+		t.Error("expected an error without discovery methods")
+	}
+
+	_, err = DiscoverWith(DiscoveryOptions{Mode: DiscoveryOff})
+	if err == nil {
+		t.Error("expected an error with discovery off")
+	}
+}
+
+func TestDiscoveryModes(t *testing.T) {
+	for _, c := range []struct {
+		options DiscoveryOptions
+		cloud   bool
+		upnp    bool
+	}{
+		{DiscoveryOptions{}, false, true},
+		{DiscoveryOptions{Mode: DiscoveryLocal}, false, true},
+		{DiscoveryOptions{Mode: DiscoveryCloud}, true, true},
+		{DiscoveryOptions{Mode: DiscoveryCloud, DisableUPnP: true}, true, false},
+		{DiscoveryOptions{Mode: DiscoveryCloud, DisableCloud: true}, false, true},
+		{DiscoveryOptions{Mode: DiscoveryOff}, false, false},
+	} {
+		cloud, upnp, err := c.options.methods()
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", c.options, err)
+			continue
+		}
+		if cloud != c.cloud || upnp != c.upnp {
+			t.Errorf("%+v: expected cloud %t upnp %t, got cloud %t upnp %t", c.options, c.cloud, c.upnp, cloud, upnp)
+		}
+	}
+
+	if _, _, err := (DiscoveryOptions{Mode: "internet"}).methods(); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
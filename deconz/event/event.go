@@ -1,8 +1,12 @@
 package event
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
+
+	"github.com/dfuchslin/deflux/logging"
 )
 
 // TypeLookuper is the interface that we require to lookup types from id's
@@ -34,9 +38,24 @@ func (d *Decoder) Parse(b []byte) (*Event, error) {
 		return nil, fmt.Errorf("unable to unmarshal json: %s", err)
 	}
 
-	// If there is no state, dont try to parse it
+	// Other resources such as lights and groups have no types to look up,
+	// their state is parsed as is
 	if e.Resource != "sensors" {
 		e.State = &EmptyState{}
+		if len(e.RawState) > 0 {
+			var s ResourceState
+			err = json.Unmarshal(e.RawState, &s)
+			if err != nil {
+				return nil, fmt.Errorf("unable to unmarshal %s state: %s", e.Resource, err)
+			}
+			e.State = &s
+		}
+		return &e, nil
+	}
+
+	// pseudo sensors such as the configuration tool have nothing worth writing
+	if t, err := d.TypeStore.LookupType(e.ID); err == nil && ignoredTypes[t] {
+		e.State = &IgnoredState{}
 		return &e, nil
 	}
 
@@ -46,13 +65,16 @@ func (d *Decoder) Parse(b []byte) (*Event, error) {
 		err = json.Unmarshal(e.Config, &s)
 		e.State = &s
 		if err != nil {
-			fmt.Printf("unable to unmarshal batterystatus: %s", err)
+			logging.Warnf("unable to unmarshal batterystatus: %s", err)
 			e.State = &EmptyState{}
 		}
 		return &e, nil
 	}
 
 	err = e.ParseState(d.TypeStore)
+	if _, ok := err.(UnknownTypeError); ok {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal state: %s", err)
 	}
@@ -60,6 +82,74 @@ func (d *Decoder) Parse(b []byte) (*Event, error) {
 	return &e, nil
 }
 
+// states creates an empty state for every known sensor type
+var states = map[string]func() fielder{
+	"ZHAFire":           func() fielder { return &ZHAFire{} },
+	"ZHATemperature":    func() fielder { return &ZHATemperature{} },
+	"ZHAPressure":       func() fielder { return &ZHAPressure{} },
+	"ZHAHumidity":       func() fielder { return &ZHAHumidity{} },
+	"ZHAWater":          func() fielder { return &ZHAWater{} },
+	"ZHASwitch":         func() fielder { return &ZHASwitch{} },
+	"Daylight":          func() fielder { return &Daylight{} },
+	"ZHAPresence":       func() fielder { return &ZHAPresence{} },
+	"CLIPPresence":      func() fielder { return &CLIPPresence{} },
+	"ZHALightLevel":     func() fielder { return &ZHALightLevel{} },
+	"ZHAVibration":      func() fielder { return &ZHAVibration{} },
+	"ZHAOpenClose":      func() fielder { return &ZHAOpenClose{} },
+	"ZHACarbonMonoxide": func() fielder { return &ZHACarbonMonoxide{} },
+	"ZHAAirQuality":     func() fielder { return &ZHAAirQuality{} },
+	"ZHAConsumption":    func() fielder { return &ZHAConsumption{} },
+	"ZHAMoisture":       func() fielder { return &ZHAMoisture{} },
+	// green power switches report button events just like zigbee switches
+	"ZGPSwitch": func() fielder { return &ZHASwitch{} },
+}
+
+// ignoredTypes are the sensor types of the gateway itself, their events are
+// parsed to an IgnoredState
+var ignoredTypes = map[string]bool{
+	"Configuration tool": true,
+}
+
+// fielder is implemented by states with timeseries data
+type fielder interface {
+	Fields() map[string]interface{}
+}
+
+// optionalFielder is implemented by states with fields that are only written when reported
+type optionalFielder interface {
+	optionalFields() map[string]interface{}
+}
+
+// SensorType describes the fields written for a sensor type
+type SensorType struct {
+	Type string
+	// Fields maps the field names to their go type, e.g. float64
+	Fields map[string]string
+}
+
+// SensorTypes returns every sensor type with a mapping, sorted by type
+func SensorTypes() []SensorType {
+	types := make([]SensorType, 0, len(states))
+	for t, newState := range states {
+		state := newState()
+		fields := state.Fields()
+		if o, ok := state.(optionalFielder); ok {
+			for k, v := range o.optionalFields() {
+				fields[k] = v
+			}
+		}
+
+		st := SensorType{Type: t, Fields: make(map[string]string, len(fields))}
+		for k, v := range fields {
+			st.Fields[k] = fmt.Sprintf("%T", v)
+		}
+		types = append(types, st)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i].Type < types[j].Type })
+	return types
+}
+
 // ParseState tries to unmarshal the appropriate state based
 // on looking up the id though the TypeStore
 func (e *Event) ParseState(tl TypeLookuper) error {
@@ -69,85 +159,38 @@ func (e *Event) ParseState(tl TypeLookuper) error {
 		return fmt.Errorf("unable to lookup event id %d: %s", e.ID, err)
 	}
 
-	switch t {
-	case "ZHAFire":
-		var s ZHAFire
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHATemperature":
-		var s ZHATemperature
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAPressure":
-		var s ZHAPressure
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAHumidity":
-		var s ZHAHumidity
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAWater":
-		var s ZHAWater
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHASwitch":
-		var s ZHASwitch
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "Daylight":
-		var s Daylight
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAPresence":
-		var s ZHAPresence
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "CLIPPresence":
-		var s CLIPPresence
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHALightLevel":
-		var s ZHALightLevel
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAVibration":
-		var s ZHAVibration
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAOpenClose":
-		var s ZHAOpenClose
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHACarbonMonoxide":
-		var s ZHACarbonMonoxide
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	default:
-		err = fmt.Errorf("unable to unmarshal event state: %s is not a known type", t)
+	newState, ok := states[t]
+	if !ok {
+		return UnknownTypeError{Type: t}
 	}
 
+	state := newState()
+	err = json.Unmarshal(e.RawState, state)
+	e.State = state
+
 	// err should continue to be null if everythings ok
 	return err
 }
 
+// UnknownTypeError is returned when parsing the state of a sensor type with no mapping
+type UnknownTypeError struct {
+	Type string
+}
+
+func (e UnknownTypeError) Error() string {
+	return fmt.Sprintf("unable to unmarshal event state: %s is not a known type", e.Type)
+}
+
 // State is for embedding into event states
 type State struct {
 	Lastupdated string
 }
 
+// LastUpdated returns when the gateway last updated the state, as formatted by the gateway
+func (s State) LastUpdated() string {
+	return s.Lastupdated
+}
+
 // ZHAHumidity represents a presure change
 type ZHAHumidity struct {
 	State
@@ -161,6 +204,19 @@ func (z *ZHAHumidity) Fields() map[string]interface{} {
 	}
 }
 
+// ZHAMoisture represents a soil moisture change, Moisture is in centi-percent
+type ZHAMoisture struct {
+	State
+	Moisture int
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAMoisture) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"moisture": float64(z.Moisture) / 100,
+	}
+}
+
 // ZHAPressure represents a presure change
 type ZHAPressure struct {
 	State
@@ -187,7 +243,8 @@ func (z *ZHATemperature) Fields() map[string]interface{} {
 	}
 }
 
-// ZHAWater respresents a change from a flood sensor
+// ZHAWater respresents a change from a flood sensor, its battery level is
+// reported separately as a BatteryStatus
 type ZHAWater struct {
 	State
 	Lowbattery bool
@@ -204,12 +261,14 @@ func (z *ZHAWater) Fields() map[string]interface{} {
 	}
 }
 
-// ZHAFire represents a change from a smoke detector
+// ZHAFire represents a change from a smoke detector, Test is set while the
+// alarm is being tested
 type ZHAFire struct {
 	State
 	Fire       bool
 	Lowbattery bool
 	Tampered   bool
+	Test       bool
 }
 
 // Fields returns timeseries data for influxdb
@@ -218,6 +277,7 @@ func (z *ZHAFire) Fields() map[string]interface{} {
 		"lowbattery": z.Lowbattery,
 		"tampered":   z.Tampered,
 		"fire":       z.Fire,
+		"test":       z.Test,
 	}
 }
 
@@ -294,20 +354,42 @@ func (z *ZHALightLevel) Fields() map[string]interface{} {
 	}
 }
 
-// ZHAVibration represents a Vibration Sensor
+// ZHAVibration represents a Vibration Sensor, Orientation is the x, y and z
+// angle of the sensor and Tiltangle its change in angle since the last event.
+// Tiltangle and Vibrationstrength are only reported along with some events
 type ZHAVibration struct {
 	State
-	Vibration bool
+	Vibration         bool
+	Orientation       []int
+	Tiltangle         *int
+	Vibrationstrength *int
 }
 
-// Fields returns timeseries data for influxdb
+// Fields returns timeseries data for influxdb, the orientation is split into
+// a field per axis as it changes too often to be a tag
 func (z *ZHAVibration) Fields() map[string]interface{} {
-	return map[string]interface{}{
+	fields := map[string]interface{}{
 		"vibration": z.Vibration,
 	}
+	if z.Tiltangle != nil {
+		fields["tiltangle"] = *z.Tiltangle
+	}
+	if z.Vibrationstrength != nil {
+		fields["vibrationstrength"] = *z.Vibrationstrength
+	}
+	if len(z.Orientation) == 3 {
+		fields["orientation_x"] = z.Orientation[0]
+		fields["orientation_y"] = z.Orientation[1]
+		fields["orientation_z"] = z.Orientation[2]
+	}
+	return fields
+}
+
+func (z *ZHAVibration) optionalFields() map[string]interface{} {
+	return map[string]interface{}{"tiltangle": 0, "vibrationstrength": 0, "orientation_x": 0, "orientation_y": 0, "orientation_z": 0}
 }
 
-// ZHAVibration represents a Vibration Sensor
+// ZHAOpenClose represents a door or window contact sensor
 type ZHAOpenClose struct {
 	State
 	Open bool
@@ -320,12 +402,14 @@ func (z *ZHAOpenClose) Fields() map[string]interface{} {
 	}
 }
 
-// ZHACarbonMonoxide represents a CarbonMonoxide Sensor
+// ZHACarbonMonoxide represents a CarbonMonoxide Sensor, Test is set while the
+// alarm is being tested
 type ZHACarbonMonoxide struct {
 	State
 	Carbonmonoxide bool
 	Lowbattery     bool
 	Tampered       bool
+	Test           bool
 }
 
 // Fields returns timeseries data for influxdb
@@ -334,21 +418,117 @@ func (z *ZHACarbonMonoxide) Fields() map[string]interface{} {
 		"CO":         z.Carbonmonoxide,
 		"lowbattery": z.Lowbattery,
 		"tampered":   z.Tampered,
+		"test":       z.Test,
+	}
+}
+
+// ZHAAirQuality represents an air quality sensor, Airquality is a string
+// rating such as "good" or "poor" and Airqualityppb the measured VOC level
+type ZHAAirQuality struct {
+	State
+	Airquality    string
+	Airqualityppb int
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAAirQuality) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"airquality":    z.Airquality,
+		"airqualityppb": z.Airqualityppb,
 	}
 }
 
-// BatteryStatus represents the current battery status
+// BatteryStatus represents the current battery status and reachability
+// reported in the config of a sensor, both are nil unless reported
 type BatteryStatus struct {
 	State
-	Battery int
+	Battery   *int
+	Reachable *bool
 }
 
 // Fields returns timeseries data for influxdb
 func (z *BatteryStatus) Fields() map[string]interface{} {
-	return map[string]interface{}{
-		"battery": z.Battery,
+	fields := make(map[string]interface{}, 2)
+	if z.Battery != nil {
+		fields["battery"] = *z.Battery
+	}
+	if z.Reachable != nil {
+		fields["reachable"] = *z.Reachable
 	}
+	return fields
 }
 
 // EmptyState is an empty struct used to indicate no state was parsed
 type EmptyState struct{}
+
+// IgnoredState is the state of sensors which are not read, such as the
+// configuration tool of the gateway
+type IgnoredState struct{}
+
+// ResourceState is the state of resources other than sensors, e.g. lights and groups
+type ResourceState map[string]interface{}
+
+// UnmarshalJSON keeps numbers as json.Number, so integers are not rounded to float64
+func (r *ResourceState) UnmarshalJSON(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var m map[string]interface{}
+	err := d.Decode(&m)
+	if err != nil {
+		return err
+	}
+	*r = m
+	return nil
+}
+
+// Fields returns the boolean and numeric state values as timeseries data for influxdb
+func (r *ResourceState) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(*r))
+	for k, v := range *r {
+		switch v := v.(type) {
+		case bool:
+			fields[k] = v
+		case json.Number:
+			f, err := v.Float64()
+			if err == nil {
+				fields[k] = f
+			}
+		}
+	}
+	return fields
+}
+
+// Integer returns field as it was sent if it is an integer, without the
+// rounding of Fields for integers beyond the precision of float64
+func (r *ResourceState) Integer(field string) (int64, bool) {
+	n, ok := (*r)[field].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return i, err == nil
+}
+
+// ZHAConsumption represents an energy meter, Consumption is the total energy
+// consumed in Wh and only ever increases until the meter is reset
+type ZHAConsumption struct {
+	State
+	Consumption int64
+	// Power is the current power in W, reported by some meters only
+	Power *int
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAConsumption) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"consumption": z.Consumption,
+	}
+	if z.Power != nil {
+		fields["power"] = *z.Power
+	}
+	return fields
+}
+
+func (z *ZHAConsumption) optionalFields() map[string]interface{} {
+	return map[string]interface{}{"power": 0}
+}
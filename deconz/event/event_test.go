@@ -20,6 +20,9 @@ const floodDetectorFloodDetectedEventPayload = `{ "e": "changed", "id": "6", "r"
 // xiaomi random switch "sensor"
 const switchSensorEventPayload = `{	"e": "changed",	"id": "7",	"r": "sensors",	"state": {	  "buttonevent": 1000,	  "lastupdated": "2018-03-20T20:52:18"	},	"t": "event"  }  `
 
+// aqara vibration sensor
+const vibrationEventPayload = `{"e":"changed","id":"9","r":"sensors","state":{"lastupdated":"2019-01-09T12:34:56","orientation":[1,-2,65],"tiltangle":72,"vibration":true,"vibrationstrength":36},"t":"event"}`
+
 type LookupImpl struct {
 	Store map[int]string
 }
@@ -36,12 +39,16 @@ var decoder Decoder
 func TestMain(m *testing.M) {
 
 	decoder = Decoder{TypeStore: &LookupImpl{Store: map[int]string{
-		1: "ZHATemperature",
-		2: "ZHAHumidity",
-		3: "ZHAPressure",
-		5: "ZHAFire",
-		6: "ZHAWater",
-		7: "ZHASwitch",
+		1:  "ZHATemperature",
+		2:  "ZHAHumidity",
+		3:  "ZHAPressure",
+		5:  "ZHAFire",
+		6:  "ZHAWater",
+		7:  "ZHASwitch",
+		9:  "ZHAVibration",
+		12: "ZHAUnmapped",
+		13: "ZGPSwitch",
+		14: "Configuration tool",
 	}}}
 	os.Exit(m.Run())
 }
@@ -52,7 +59,7 @@ func TestSmokeDetectorNoFireEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	smokeDetectorEvent, success := result.State.(ZHAFire)
+	smokeDetectorEvent, success := result.State.(*ZHAFire)
 	if !success {
 		t.Log("unable to type assert smoke detector event")
 		t.FailNow()
@@ -71,7 +78,7 @@ func TestFloodDetectorEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	floodEvent, success := result.State.(ZHAWater)
+	floodEvent, success := result.State.(*ZHAWater)
 	if !success {
 		t.Log("Unable to type assert floodevent")
 		t.FailNow()
@@ -91,7 +98,7 @@ func TestPressureEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	pressure, success := result.State.(ZHAPressure)
+	pressure, success := result.State.(*ZHAPressure)
 	if !success {
 		t.Log("Coudl not assert to pressureevent")
 		t.FailNow()
@@ -110,7 +117,7 @@ func TestTemperatureEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	temp, success := result.State.(ZHATemperature)
+	temp, success := result.State.(*ZHATemperature)
 	if !success {
 		t.Logf("Could not assert to temperature event")
 		t.FailNow()
@@ -129,7 +136,7 @@ func TestHumidityEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	humidity, success := result.State.(ZHAHumidity)
+	humidity, success := result.State.(*ZHAHumidity)
 	if !success {
 		t.Logf("unable assert humidity event")
 		t.FailNow()
@@ -148,7 +155,7 @@ func TestSwitchEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	s, success := result.State.(ZHASwitch)
+	s, success := result.State.(*ZHASwitch)
 	if !success {
 		t.Logf("unable assert switch event")
 		t.FailNow()
@@ -158,3 +165,117 @@ func TestSwitchEvent(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// philips hue tap, a green power switch
+const greenPowerSwitchEventPayload = `{"e":"changed","id":"13","r":"sensors","state":{"buttonevent":34,"lastupdated":"2021-03-01T12:00:00"},"t":"event"}`
+
+func TestGreenPowerSwitchEvent(t *testing.T) {
+	result, err := decoder.Parse([]byte(greenPowerSwitchEventPayload))
+	if err != nil {
+		t.Logf("Could not parse green power switch event: %s", err)
+		t.FailNow()
+	}
+
+	s, success := result.State.(*ZHASwitch)
+	if !success {
+		t.Logf("unable assert green power switch event")
+		t.FailNow()
+	}
+	if s.Buttonevent != 34 {
+		t.Errorf("unexpected buttonevent %d", s.Buttonevent)
+	}
+}
+
+func TestConfigurationToolEvent(t *testing.T) {
+	for _, payload := range []string{
+		`{"e":"changed","id":"14","r":"sensors","state":{"lastupdated":"2021-03-01T12:00:00"},"t":"event"}`,
+		`{"e":"changed","id":"14","r":"sensors","config":{"on":true,"reachable":true},"t":"event"}`,
+	} {
+		result, err := decoder.Parse([]byte(payload))
+		if err != nil {
+			t.Logf("Could not parse configuration tool event: %s", err)
+			t.FailNow()
+		}
+		if _, ok := result.State.(*IgnoredState); !ok {
+			t.Errorf("expected the configuration tool to be ignored, got %T", result.State)
+		}
+	}
+}
+
+const lightEventPayload = `{"e":"changed","id":"3","r":"lights","state":{"on":true,"bri":127,"alert":"none","reachable":true},"t":"event"}`
+
+func TestLightEvent(t *testing.T) {
+	result, err := decoder.Parse([]byte(lightEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	l, success := result.State.(*ResourceState)
+	if !success {
+		t.Fatalf("expected ResourceState, got %T", result.State)
+	}
+
+	fields := l.Fields()
+	if fields["on"] != true || fields["bri"] != float64(127) || fields["reachable"] != true {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if _, found := fields["alert"]; found {
+		t.Error("strings should not be fields")
+	}
+}
+
+func TestVibrationEvent(t *testing.T) {
+	result, err := decoder.Parse([]byte(vibrationEventPayload))
+	if err != nil {
+		t.Logf("Could not parse vibration event: %s", err)
+		t.FailNow()
+	}
+
+	vibrationEvent, success := result.State.(*ZHAVibration)
+	if !success {
+		t.Log("Unable to type assert vibration event")
+		t.FailNow()
+	}
+
+	fields := vibrationEvent.Fields()
+	if fields["vibration"] != true || fields["tiltangle"] != 72 || fields["vibrationstrength"] != 36 {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if fields["orientation_x"] != 1 || fields["orientation_y"] != -2 || fields["orientation_z"] != 65 {
+		t.Errorf("unexpected orientation %v", fields)
+	}
+
+	// tiltangle and vibrationstrength are left out of events not reporting them
+	result, err = decoder.Parse([]byte(`{"e":"changed","id":"9","r":"sensors","state":{"lastupdated":"2019-01-09T12:35:56","vibration":false},"t":"event"}`))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	fields = result.State.(*ZHAVibration).Fields()
+	if len(fields) != 1 || fields["vibration"] != false {
+		t.Errorf("expected only the vibration field, got %v", fields)
+	}
+}
+
+func TestSensorTypes(t *testing.T) {
+	types := SensorTypes()
+	if len(types) != len(states) {
+		t.Errorf("expected %d types, got %d", len(states), len(types))
+	}
+
+	for i, st := range types {
+		if i > 0 && types[i-1].Type >= st.Type {
+			t.Errorf("types are not sorted: %s before %s", types[i-1].Type, st.Type)
+		}
+		if len(st.Fields) == 0 {
+			t.Errorf("%s has no fields", st.Type)
+		}
+		if st.Type == "ZHATemperature" && st.Fields["temperature"] != "float64" {
+			t.Errorf("unexpected ZHATemperature fields %v", st.Fields)
+		}
+		if st.Type == "ZHAVibration" && st.Fields["orientation_x"] != "int" {
+			t.Errorf("expected optional fields of ZHAVibration, got %v", st.Fields)
+		}
+	}
+}
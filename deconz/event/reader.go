@@ -3,8 +3,10 @@ package event
 import (
 	"errors"
 	"fmt"
-	"log"
+	"net/http"
+	"time"
 
+	"github.com/dfuchslin/deflux/logging"
 	"github.com/gorilla/websocket"
 )
 
@@ -12,19 +14,37 @@ import (
 type Reader struct {
 	WebsocketAddr string
 	TypeStore     TypeLookuper
-	decoder       *Decoder
-	conn          *websocket.Conn
+	// Header is sent along with the websocket handshake
+	Header http.Header
+	// Subprotocols are requested during the websocket handshake
+	Subprotocols []string
+	// DialTimeout bounds connecting and the websocket handshake, zero uses the
+	// default of the websocket package
+	DialTimeout time.Duration
+	// Tap receives every frame read before it is parsed if set, it must not
+	// modify or block on the frame
+	Tap func(frame []byte)
+	// ReadBufferSize and WriteBufferSize are the sizes of the buffers of
+	// the connection, zero uses the default of the websocket package
+	ReadBufferSize  int
+	WriteBufferSize int
+	decoder         *Decoder
+	conn            *websocket.Conn
+	// unknownTypes are the sensor types already warned about having no mapping
+	unknownTypes map[string]bool
 }
 
-
 type EventError interface {
 	error
 	Recoverable() bool
 }
 
 type EventErrorImpl struct {
-	errStr string
+	errStr      string
 	recoverable bool
+	// cause and payload are set for frames that could not be parsed
+	cause   error
+	payload []byte
 }
 
 func (e EventErrorImpl) Recoverable() bool {
@@ -35,6 +55,16 @@ func (e EventErrorImpl) Error() string {
 	return e.errStr
 }
 
+// Unwrap returns the error parsing the frame, e.g. an UnknownTypeError
+func (e EventErrorImpl) Unwrap() error {
+	return e.cause
+}
+
+// Payload returns the frame that could not be parsed, if any
+func (e EventErrorImpl) Payload() []byte {
+	return e.payload
+}
+
 // Dial connects connects to deconz, use ReadEvent to recieve events
 func (r *Reader) Dial() error {
 
@@ -47,7 +77,15 @@ func (r *Reader) Dial() error {
 
 	// connect
 	var err error
-	r.conn, _, err = websocket.DefaultDialer.Dial(r.WebsocketAddr, nil)
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = r.Subprotocols
+	dialer.ReadBufferSize = r.ReadBufferSize
+	dialer.WriteBufferSize = r.WriteBufferSize
+	if r.DialTimeout > 0 {
+		dialer.HandshakeTimeout = r.DialTimeout
+	}
+	logging.Tracef("dialing websocket %s with headers %v", r.WebsocketAddr, r.Header)
+	r.conn, _, err = dialer.Dial(r.WebsocketAddr, r.Header)
 	if err != nil {
 		return fmt.Errorf("unable to dail %s: %s", r.WebsocketAddr, err)
 	}
@@ -57,18 +95,46 @@ func (r *Reader) Dial() error {
 // ReadEvent reads, parses and returns the next event
 func (r *Reader) ReadEvent() (*Event, error) {
 
-	_, message, err := r.conn.ReadMessage()
+	messageType, message, err := r.conn.ReadMessage()
 	if err != nil {
 		return nil, fmt.Errorf("event read error: %s", err)
 	}
+	logging.Tracef("websocket frame of type %d: %q", messageType, message)
+	if r.Tap != nil {
+		r.Tap(message)
+	}
 
-	log.Printf("recv: %s", message)
+	logging.Debugf("recv: %s", message)
+
+	e, err := r.decoder.ParseFrame(message)
+	if eerr, ok := err.(EventErrorImpl); ok {
+		if terr, ok := eerr.cause.(UnknownTypeError); ok {
+			// events of unmapped types are dropped, make sure it is noticed
+			// without repeating it for every event
+			if !r.unknownTypes[terr.Type] {
+				if r.unknownTypes == nil {
+					r.unknownTypes = make(map[string]bool)
+				}
+				r.unknownTypes[terr.Type] = true
+				logging.Warnf("dropping events of sensor type %s, it has no mapping", terr.Type)
+			}
+		} else {
+			logging.Debugf("unable to parse frame %q: %s", message, eerr.cause)
+		}
+	}
+	return e, err
+}
 
-	e, err := r.decoder.Parse(message)
+// ParseFrame parses frame like Parse, every error is returned as a
+// recoverable EventErrorImpl carrying the frame, so it can be quarantined
+func (d *Decoder) ParseFrame(frame []byte) (*Event, error) {
+	e, err := d.Parse(frame)
+	if _, ok := err.(UnknownTypeError); ok {
+		return nil, EventErrorImpl{errStr: err.Error(), recoverable: true, cause: err, payload: frame}
+	}
 	if err != nil {
-		return nil, EventErrorImpl{fmt.Errorf("unable to parse message: %s", err).Error(), true}
+		return nil, EventErrorImpl{errStr: fmt.Errorf("unable to parse message: %s", err).Error(), recoverable: true, cause: err, payload: frame}
 	}
-
 	return e, nil
 }
 
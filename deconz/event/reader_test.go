@@ -0,0 +1,210 @@
+package event
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketServer starts a websocket server sending messages to every client connecting to it
+func websocketServer(t *testing.T, upgrader websocket.Upgrader, messages ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("upgrade failed: %s", err)
+			return
+		}
+		defer conn.Close()
+
+		for _, m := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(m))
+		}
+		// wait for the client to hang up
+		conn.ReadMessage()
+	}))
+}
+
+func TestReaderHandshake(t *testing.T) {
+	var origin string
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"deconz"},
+		CheckOrigin: func(r *http.Request) bool {
+			origin = r.Header.Get("Origin")
+			return origin == "http://gateway.local"
+		},
+	}
+	server := websocketServer(t, upgrader, temperatureEventPayload)
+	defer server.Close()
+
+	r := Reader{
+		WebsocketAddr: "ws" + strings.TrimPrefix(server.URL, "http"),
+		TypeStore:     decoder.TypeStore,
+		Header:        http.Header{"Origin": []string{"http://gateway.local"}},
+		Subprotocols:  []string{"deconz"},
+	}
+	err := r.Dial()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer r.Close()
+
+	if r.conn.Subprotocol() != "deconz" {
+		t.Errorf("expected subprotocol deconz, got %q", r.conn.Subprotocol())
+	}
+
+	e, err := r.ReadEvent()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if e.ID != 1 {
+		t.Errorf("unexpected event %v", e)
+	}
+}
+
+func TestReaderBufferSizes(t *testing.T) {
+	server := websocketServer(t, websocket.Upgrader{}, temperatureEventPayload)
+	defer server.Close()
+
+	// frames larger than the read buffer are still read in full
+	r := Reader{
+		WebsocketAddr:   "ws" + strings.TrimPrefix(server.URL, "http"),
+		TypeStore:       decoder.TypeStore,
+		ReadBufferSize:  16,
+		WriteBufferSize: 16,
+	}
+	err := r.Dial()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer r.Close()
+
+	e, err := r.ReadEvent()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if e.ID != 1 {
+		t.Errorf("unexpected event %v", e)
+	}
+}
+
+func TestReaderTap(t *testing.T) {
+	server := websocketServer(t, websocket.Upgrader{}, "\x00{garbage", temperatureEventPayload)
+	defer server.Close()
+
+	// frames are tapped whether or not they can be parsed
+	var frames []string
+	r := Reader{
+		WebsocketAddr: "ws" + strings.TrimPrefix(server.URL, "http"),
+		TypeStore:     decoder.TypeStore,
+		Tap:           func(frame []byte) { frames = append(frames, string(frame)) },
+	}
+	err := r.Dial()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer r.Close()
+
+	r.ReadEvent()
+	r.ReadEvent()
+	if len(frames) != 2 || frames[0] != "\x00{garbage" || frames[1] != temperatureEventPayload {
+		t.Errorf("expected both frames to be tapped, got %q", frames)
+	}
+}
+
+func TestReaderMalformedFrame(t *testing.T) {
+	server := websocketServer(t, websocket.Upgrader{}, "\x00{garbage", temperatureEventPayload)
+	defer server.Close()
+
+	r := Reader{WebsocketAddr: "ws" + strings.TrimPrefix(server.URL, "http"), TypeStore: decoder.TypeStore}
+	err := r.Dial()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer r.Close()
+
+	_, err = r.ReadEvent()
+	if eerr, ok := err.(EventError); !ok || !eerr.Recoverable() {
+		t.Errorf("expected a recoverable error, got %v", err)
+	}
+
+	e, err := r.ReadEvent()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if e.ID != 1 {
+		t.Errorf("unexpected event %v", e)
+	}
+}
+
+func TestReaderUnknownType(t *testing.T) {
+	unmapped := `{"e":"changed","id":"12","r":"sensors","state":{"lastupdated":"2020-02-02T02:02:02"},"t":"event"}`
+	server := websocketServer(t, websocket.Upgrader{}, unmapped, unmapped)
+	defer server.Close()
+
+	r := Reader{WebsocketAddr: "ws" + strings.TrimPrefix(server.URL, "http"), TypeStore: decoder.TypeStore}
+	err := r.Dial()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer r.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err = r.ReadEvent()
+		if eerr, ok := err.(EventError); !ok || !eerr.Recoverable() {
+			t.Errorf("expected a recoverable error, got %v", err)
+		}
+		var terr UnknownTypeError
+		if !errors.As(err, &terr) || terr.Type != "ZHAUnmapped" {
+			t.Errorf("expected an unknown type error, got %v", err)
+		}
+		if perr, ok := err.(EventErrorImpl); !ok || string(perr.Payload()) != unmapped {
+			t.Errorf("expected the frame along with the error, got %v", err)
+		}
+	}
+	if len(r.unknownTypes) != 1 || !r.unknownTypes["ZHAUnmapped"] {
+		t.Errorf("expected ZHAUnmapped to be warned about once, got %v", r.unknownTypes)
+	}
+}
+
+func TestReaderDialTimeout(t *testing.T) {
+	// a server accepting connections but never answering the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	r := Reader{WebsocketAddr: "ws://" + listener.Addr().String(), TypeStore: decoder.TypeStore, DialTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	err = r.Dial()
+	if err == nil {
+		t.Fatal("expected the stalled handshake to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("dial took %s despite the timeout", elapsed)
+	}
+}
@@ -0,0 +1,60 @@
+package deconz
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TagNormalization cleans up tag keys and values, e.g. sensor names with
+// spaces, the zero value leaves them as they are
+type TagNormalization struct {
+	// Lowercase lowercases keys and values
+	Lowercase bool
+	// ReplaceSpaces replaces every space with the given string, e.g. "_"
+	ReplaceSpaces string
+	// StripNonAlphanumeric removes everything but letters, digits, "_", "-" and "."
+	StripNonAlphanumeric bool
+	// ReplaceSpecial replaces commas, equal signs, spaces and backslashes, the
+	// characters needing escapes in line protocol, with the given string
+	ReplaceSpecial string
+}
+
+func (n TagNormalization) enabled() bool {
+	return n.Lowercase || n.ReplaceSpaces != "" || n.StripNonAlphanumeric || n.ReplaceSpecial != ""
+}
+
+// normalize returns s cleaned up as configured
+func (n TagNormalization) normalize(s string) string {
+	if n.Lowercase {
+		s = strings.ToLower(s)
+	}
+	if n.ReplaceSpaces != "" {
+		s = strings.Replace(s, " ", n.ReplaceSpaces, -1)
+	}
+	if n.ReplaceSpecial != "" {
+		s = specialReplacer(n.ReplaceSpecial).Replace(s)
+	}
+	if n.StripNonAlphanumeric {
+		s = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	return s
+}
+
+// specialReplacer replaces the characters escaped in line protocol tags with r
+func specialReplacer(r string) *strings.Replacer {
+	return strings.NewReplacer(",", r, "=", r, " ", r, "\\", r)
+}
+
+// normalizeTags returns tags with normalized keys and values
+func (n TagNormalization) normalizeTags(tags map[string]string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for k, v := range tags {
+		result[n.normalize(k)] = n.normalize(v)
+	}
+	return result
+}
@@ -0,0 +1,48 @@
+package deconz
+
+import (
+	"testing"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+)
+
+func TestTagNormalization(t *testing.T) {
+	for _, c := range []struct {
+		n        TagNormalization
+		expected string
+	}{
+		{TagNormalization{}, "Kælder bad (2)"},
+		{TagNormalization{Lowercase: true}, "kælder bad (2)"},
+		{TagNormalization{ReplaceSpaces: "_"}, "Kælder_bad_(2)"},
+		{TagNormalization{StripNonAlphanumeric: true}, "Kælderbad2"},
+		{TagNormalization{Lowercase: true, ReplaceSpaces: "_", StripNonAlphanumeric: true}, "kælder_bad_2"},
+		{TagNormalization{ReplaceSpecial: "-"}, "Kælder-bad-(2)"},
+	} {
+		if normalized := c.n.normalize("Kælder bad (2)"); normalized != c.expected {
+			t.Errorf("%+v: expected %q, got %q", c.n, c.expected, normalized)
+		}
+	}
+}
+
+func TestTimeseriesTagNormalization(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	parsed, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	e := &SensorEvent{
+		Event:   parsed,
+		Sensor:  &Sensor{Name: "Kælder bad", Type: "ZHAFire"},
+		options: &TimeseriesOptions{Normalization: TagNormalization{Lowercase: true, ReplaceSpaces: "_"}},
+	}
+	tags, _, err := e.Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["name"] != "kælder_bad" || tags["type"] != "zhafire" || tags["id"] != "5" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
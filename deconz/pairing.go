@@ -34,8 +34,9 @@ type pairFailure struct {
 
 // Pair tries to pair with deconz and returns a pairing with an API key
 func Pair(u url.URL) (APIKey, error) {
-	// to pair we must send a POST request to "/api" containing a pairRequest
-	u.Path = "/api"
+	// to pair we must send a POST request to "/api" containing a pairRequest,
+	// keeping the path prefix of gateways behind a reverse proxy
+	u.Path = pathPrefix(u.Path) + "/api"
 
 	pr := pairRequest{
 		DeviceType: "Deflux",
@@ -49,7 +50,14 @@ func Pair(u url.URL) (APIKey, error) {
 	}
 
 	// send POST request and read body
-	response, err := http.Post(u.String(), "application/json", &buff)
+	req, err := http.NewRequest(http.MethodPost, u.String(), &buff)
+	if err != nil {
+		return "", fmt.Errorf("unable to create post request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", DefaultUserAgent)
+
+	response, err := httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("unable to send post request: %s", err)
 	}
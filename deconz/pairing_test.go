@@ -0,0 +1,34 @@
+package deconz
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/dfuchslin/deflux/internal/deconztest"
+)
+
+func TestPair(t *testing.T) {
+	gateway := deconztest.NewGateway("secret", "{}")
+	defer gateway.Close()
+
+	u, err := url.Parse(gateway.Addr())
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	key, err := Pair(*u)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if key != "secret" {
+		t.Errorf("expected api key secret, got %s", key)
+	}
+
+	gateway.Locked = true
+	_, err = Pair(*u)
+	if err == nil {
+		t.Error("expected pairing a locked gateway to fail")
+	}
+}
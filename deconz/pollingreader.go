@@ -0,0 +1,161 @@
+package deconz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// DefaultMinPollInterval is the lowest poll interval allowed unless configured otherwise,
+// the gateway answers the full sensor list on every poll and polling more often
+// than this tends to slow down the gateway for other clients
+const DefaultMinPollInterval = 5 * time.Second
+
+// SensorStateGetter defines how we like to ask for the current state of all sensors
+type SensorStateGetter interface {
+	SensorStates() (map[int]json.RawMessage, error)
+}
+
+// PollingReader is an EventReader which polls the rest api for sensor state changes
+// instead of listening to the websocket
+type PollingReader struct {
+	Interval    time.Duration
+	TypeStore   event.TypeLookuper
+	StateGetter SensorStateGetter
+	lastupdated map[int]string
+	pending     []polledEvent
+	closed      chan struct{}
+}
+
+// polledEvent is an event read by polling, or the error parsing it
+type polledEvent struct {
+	event *event.Event
+	err   error
+}
+
+// polledFrame is the websocket frame equivalent to a polled state, polled
+// states are parsed from it so they are read just like websocket events
+type polledFrame struct {
+	Type     string          `json:"t"`
+	Event    string          `json:"e"`
+	Resource string          `json:"r"`
+	ID       int             `json:"id,string"`
+	State    json.RawMessage `json:"state"`
+}
+
+// pollInterval clamps interval to floor, using DefaultMinPollInterval if no floor is given
+func pollInterval(interval, floor time.Duration) time.Duration {
+	if floor <= 0 {
+		floor = DefaultMinPollInterval
+	}
+
+	if interval < floor {
+		logging.Warnf("Poll interval %s is below the minimum of %s, polling every %s instead", interval, floor, floor)
+		return floor
+	}
+
+	return interval
+}
+
+// Dial fetches the current sensor states, only changes from here on will be returned as events
+func (r *PollingReader) Dial() error {
+	if r.TypeStore == nil {
+		return errors.New("cannot dial without a TypeStore to lookup events from")
+	}
+	if r.StateGetter == nil {
+		return errors.New("cannot dial without a StateGetter to poll sensors from")
+	}
+
+	states, err := r.StateGetter.SensorStates()
+	if err != nil {
+		return fmt.Errorf("unable to poll sensors: %s", err)
+	}
+
+	r.lastupdated = make(map[int]string, len(states))
+	for id, state := range states {
+		r.lastupdated[id] = lastupdated(state)
+	}
+	r.pending = nil
+	r.closed = make(chan struct{})
+
+	return nil
+}
+
+// ReadEvent returns the next sensor state change, polling the rest api until one occurs
+func (r *PollingReader) ReadEvent() (*event.Event, error) {
+	for len(r.pending) == 0 {
+		select {
+		case <-r.closed:
+			return nil, errors.New("polling reader closed")
+		case <-time.After(r.Interval):
+		}
+
+		err := r.poll()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := r.pending[0]
+	r.pending = r.pending[1:]
+	return p.event, p.err
+}
+
+// poll queues an event for every sensor whose state has been updated since the
+// last poll, states that can not be parsed are queued as their error so they
+// are quarantined like frames of the websocket
+func (r *PollingReader) poll() error {
+	states, err := r.StateGetter.SensorStates()
+	if err != nil {
+		return fmt.Errorf("unable to poll sensors: %s", err)
+	}
+
+	for id, state := range states {
+		updated := lastupdated(state)
+		if previous, found := r.lastupdated[id]; found && previous == updated {
+			continue
+		}
+		r.lastupdated[id] = updated
+
+		frame, err := json.Marshal(polledFrame{Type: "event", Event: "changed", Resource: "sensors", ID: id, State: state})
+		if err != nil {
+			logging.Warnf("Dropping polled state of sensor %d: %s", id, err)
+			continue
+		}
+		e, err := (&event.Decoder{TypeStore: r.TypeStore}).ParseFrame(frame)
+		if err != nil {
+			logging.Warnf("Dropping polled state of sensor %d: %s", id, err)
+		}
+		r.pending = append(r.pending, polledEvent{event: e, err: err})
+	}
+
+	return nil
+}
+
+// Close stops polling
+func (r *PollingReader) Close() error {
+	if r.closed == nil {
+		return nil
+	}
+
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	return nil
+}
+
+// lastupdated extracts the lastupdated timestamp from a raw sensor state
+func lastupdated(state json.RawMessage) string {
+	var s event.State
+	err := json.Unmarshal(state, &s)
+	if err != nil {
+		return ""
+	}
+	return s.Lastupdated
+}
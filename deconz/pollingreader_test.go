@@ -0,0 +1,106 @@
+package deconz
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+)
+
+type testStateGetter struct {
+	states map[int]json.RawMessage
+}
+
+func (t *testStateGetter) SensorStates() (map[int]json.RawMessage, error) {
+	return t.states, nil
+}
+
+func TestPollIntervalFloor(t *testing.T) {
+	if i := pollInterval(time.Second, 0); i != DefaultMinPollInterval {
+		t.Errorf("expected %s, got %s", DefaultMinPollInterval, i)
+	}
+	if i := pollInterval(time.Second, 2*time.Second); i != 2*time.Second {
+		t.Errorf("expected 2s, got %s", i)
+	}
+	if i := pollInterval(time.Minute, 0); i != time.Minute {
+		t.Errorf("expected 1m, got %s", i)
+	}
+}
+
+func TestPollingReader(t *testing.T) {
+	getter := &testStateGetter{states: map[int]json.RawMessage{
+		5: json.RawMessage(`{"fire":false,"lastupdated":"2018-03-13T19:46:03"}`),
+	}}
+	r := PollingReader{Interval: time.Millisecond, TypeStore: &testLookup{}, StateGetter: getter}
+
+	err := r.Dial()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	getter.states = map[int]json.RawMessage{
+		5: json.RawMessage(`{"fire":true,"lastupdated":"2018-03-13T19:47:03"}`),
+	}
+
+	e, err := r.ReadEvent()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if e.ID != 5 {
+		t.Fail()
+	}
+	if s, ok := e.State.(fielder); !ok || s.Fields()["fire"] != true {
+		t.Errorf("expected fire state, got %#v", e.State)
+	}
+
+	r.Close()
+	_, err = r.ReadEvent()
+	if err == nil {
+		t.Error("expected error reading from closed reader")
+	}
+}
+
+func TestPollingReaderParsesLikeWebsocket(t *testing.T) {
+	getter := &testStateGetter{states: map[int]json.RawMessage{}}
+	r := PollingReader{Interval: time.Millisecond, TypeStore: typeLookup{1: "Configuration tool", 2: "ZHAFire", 3: "ZHAFire"}, StateGetter: getter}
+	err := r.Dial()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	getter.states = map[int]json.RawMessage{
+		1: json.RawMessage(`{"lastupdated":"2018-03-13T19:47:03"}`),
+		2: json.RawMessage(`{"fire":"yes","lastupdated":"2018-03-13T19:47:03"}`),
+		3: json.RawMessage(`{"fire":true,"lastupdated":"2018-03-13T19:47:03"}`),
+	}
+
+	// ignored types and unparseable states are left to the SensorEventReader,
+	// which drops and quarantines them as it does for the websocket
+	for i := 0; i < 3; i++ {
+		e, err := r.ReadEvent()
+		if err != nil {
+			eerr, ok := err.(event.EventErrorImpl)
+			if !ok || !eerr.Recoverable() || !strings.Contains(string(eerr.Payload()), `"id":"2"`) {
+				t.Errorf("expected a recoverable error carrying the frame of sensor 2, got %v", err)
+			}
+			continue
+		}
+		switch e.ID {
+		case 1:
+			if _, ok := e.State.(*event.IgnoredState); !ok {
+				t.Errorf("expected the configuration tool to be ignored, got %#v", e.State)
+			}
+		case 3:
+			if s, ok := e.State.(fielder); !ok || s.Fields()["fire"] != true {
+				t.Errorf("expected fire state, got %#v", e.State)
+			}
+		default:
+			t.Errorf("unexpected event of sensor %d", e.ID)
+		}
+	}
+}
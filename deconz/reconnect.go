@@ -0,0 +1,74 @@
+package deconz
+
+import (
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// defaults of ReconnectOptions
+const (
+	DefaultReconnectAttempts = 10
+	DefaultReconnectDelay    = 5 * time.Second
+	DefaultReconnectMaxDelay = 30 * time.Second
+)
+
+// ReconnectOptions configures how requests and connections to the gateway are retried
+type ReconnectOptions struct {
+	// Attempts bounds the tries of the requests made at startup, so a gateway
+	// that is still booting is waited for, defaults to DefaultReconnectAttempts
+	Attempts int
+	// Delay is the wait between websocket reconnects and before the first retry
+	// at startup, defaults to DefaultReconnectDelay
+	Delay time.Duration
+	// MaxDelay caps the delay doubling between retries at startup, defaults to DefaultReconnectMaxDelay
+	MaxDelay time.Duration
+	// RediscoverAfter searches the local network for the gateway after this
+	// many websocket reconnects failed in a row, and switches to its new
+	// address if it moved, zero disables it
+	RediscoverAfter int
+}
+
+func (o ReconnectOptions) attempts() int {
+	if o.Attempts > 0 {
+		return o.Attempts
+	}
+	return DefaultReconnectAttempts
+}
+
+func (o ReconnectOptions) delay() time.Duration {
+	if o.Delay > 0 {
+		return o.Delay
+	}
+	return DefaultReconnectDelay
+}
+
+func (o ReconnectOptions) maxDelay() time.Duration {
+	if o.MaxDelay > 0 {
+		return o.MaxDelay
+	}
+	return DefaultReconnectMaxDelay
+}
+
+// retry calls f until it succeeds or the attempts are used, backing off
+// between tries, the last error is returned
+func (o ReconnectOptions) retry(what string, f func() error) error {
+	delay := o.delay()
+	attempts := o.attempts()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || attempt >= attempts {
+			return err
+		}
+
+		logging.Warnf("%s failed (attempt %d of %d): %s, retrying in %s", what, attempt, attempts, err, delay)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > o.maxDelay() {
+			delay = o.maxDelay()
+		}
+	}
+}
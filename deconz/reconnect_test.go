@@ -0,0 +1,39 @@
+package deconz
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconnectRetry(t *testing.T) {
+	o := ReconnectOptions{Attempts: 3, Delay: time.Millisecond}
+
+	calls := 0
+	err := o.retry("test", func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("booting")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("expected success on the second call, got %d calls and %v", calls, err)
+	}
+
+	calls = 0
+	err = o.retry("test", func() error {
+		calls++
+		return errors.New("booting")
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("expected an error after 3 calls, got %d calls and %v", calls, err)
+	}
+}
+
+func TestReconnectDefaults(t *testing.T) {
+	var o ReconnectOptions
+	if o.attempts() != DefaultReconnectAttempts || o.delay() != DefaultReconnectDelay || o.maxDelay() != DefaultReconnectMaxDelay {
+		t.Errorf("unexpected defaults %d %s %s", o.attempts(), o.delay(), o.maxDelay())
+	}
+}
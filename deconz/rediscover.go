@@ -0,0 +1,75 @@
+package deconz
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// discoverGateways is replaced in tests
+var discoverGateways = DiscoverWith
+
+// rediscover searches for the gateway by its bridge id with the configured
+// discovery methods, if it is found at another address the api and r are
+// pointed at it
+func (a *API) rediscover(r *event.Reader) error {
+	if a.Config.bridgeID == "" {
+		return errors.New("the bridge id of the gateway is unknown")
+	}
+
+	gateways, err := discoverGateways(a.Config.Discovery)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range gateways {
+		if !strings.EqualFold(g.ID, a.Config.bridgeID) {
+			continue
+		}
+
+		addr, err := movedAddr(a.Config.Addr, g)
+		if err != nil {
+			return err
+		}
+		if addr == a.Config.Addr {
+			return fmt.Errorf("gateway %s is still at %s", g.ID, addr)
+		}
+
+		moved := a.Config
+		moved.Addr = addr
+		err = moved.discoverWebsocket()
+		if err != nil {
+			return err
+		}
+		logging.Warnf("gateway %s moved from %s to %s", g.ID, a.Config.Addr, addr)
+		// the timeseries options are shared with the events, leave them be
+		a.Config.Addr, a.Config.wsAddr = moved.Addr, moved.wsAddr
+		r.WebsocketAddr = moved.wsAddr
+		return nil
+	}
+	return fmt.Errorf("gateway %s was not discovered", a.Config.bridgeID)
+}
+
+// movedAddr returns addr with its host replaced by the address g was found at
+func movedAddr(addr string, g Gateway) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	port := g.InternalPort
+	if port == 0 {
+		p, _ := strconv.Atoi(u.Port())
+		port = uint(p)
+	}
+	u.Host = g.InternalIPAddress
+	if port > 0 {
+		u.Host = net.JoinHostPort(g.InternalIPAddress, strconv.Itoa(int(port)))
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,139 @@
+package deconz
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/internal/deconztest"
+)
+
+func TestRediscover(t *testing.T) {
+	old := deconztest.NewGateway("secret", "{}")
+	old.BridgeID = "00212EFFFF017FBD"
+	moved := deconztest.NewGateway("secret", "{}")
+	moved.BridgeID = "00212EFFFF017FBD"
+	defer moved.Close()
+
+	a := API{Config: Config{Addr: old.Addr(), APIKey: "secret"}}
+	r, err := a.EventReader()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	old.Close()
+
+	u, _ := url.Parse(moved.Addr())
+	port, _ := strconv.Atoi(u.Port())
+	found := []Gateway{{ID: "00212effff017fbd", InternalIPAddress: u.Hostname(), InternalPort: uint(port)}}
+	discoverGateways = func(DiscoveryOptions) ([]Gateway, error) { return found, nil }
+	defer func() { discoverGateways = DiscoverWith }()
+
+	err = a.rediscover(r)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if a.Config.Addr != moved.Addr() {
+		t.Errorf("expected the api to use %s, got %s", moved.Addr(), a.Config.Addr)
+	}
+	err = r.Dial()
+	if err != nil {
+		t.Errorf("expected the websocket of the moved gateway to be dialed: %s", err)
+	} else {
+		r.Close()
+	}
+
+	if err := a.rediscover(r); err == nil {
+		t.Error("expected an error for a gateway still at its address")
+	}
+	found[0].ID = "00212EFFFF000000"
+	if err := a.rediscover(r); err == nil {
+		t.Error("expected an error for other gateways discovered")
+	}
+}
+
+// movingReader loses its connection and can not be dialed until it moved
+type movingReader struct {
+	testReader
+	dials  int
+	failed int
+	moved  bool
+}
+
+func (m *movingReader) Dial() error {
+	m.dials++
+	if m.dials > 1 && !m.moved {
+		m.failed++
+		return errors.New("no route to host")
+	}
+	return nil
+}
+
+func (m *movingReader) ReadEvent() (*event.Event, error) {
+	if !m.moved {
+		return nil, errors.New("connection reset")
+	}
+	return m.testReader.ReadEvent()
+}
+
+func TestSensorEventReaderRediscover(t *testing.T) {
+	reader := &movingReader{}
+	rediscovered := 0
+	r := SensorEventReader{lookup: &testLookup{}, reader: reader, reconnect: ReconnectOptions{Delay: time.Millisecond, RediscoverAfter: 3}}
+	r.rediscover = func() error {
+		rediscovered++
+		reader.moved = true
+		return nil
+	}
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	<-channel
+	r.StopReadEvents()
+	if rediscovered != 1 || reader.failed != 3 {
+		t.Errorf("expected a rediscovery after 3 failed reconnects, got %d after %d", rediscovered, reader.failed)
+	}
+}
+
+func TestRediscoverDiscoveryMode(t *testing.T) {
+	gateway := deconztest.NewGateway("secret", "{}")
+	gateway.BridgeID = "00212EFFFF017FBD"
+	defer gateway.Close()
+
+	var used DiscoveryOptions
+	discoverGateways = func(o DiscoveryOptions) ([]Gateway, error) {
+		used = o
+		return nil, nil
+	}
+	defer func() { discoverGateways = DiscoverWith }()
+
+	// the configured discovery methods are used
+	a := API{Config: Config{Addr: gateway.Addr(), APIKey: "secret", Discovery: DiscoveryOptions{Mode: DiscoveryCloud}, Reconnect: ReconnectOptions{RediscoverAfter: 3}}}
+	r, err := a.EventReader()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	reader := a.SensorEventReader(r)
+	if reader.rediscover == nil {
+		t.Fatal("expected rediscovery to be enabled")
+	}
+	reader.rediscover()
+	if used.Mode != DiscoveryCloud {
+		t.Errorf("expected to rediscover with the %s mode, got %q", DiscoveryCloud, used.Mode)
+	}
+
+	// and none at all while discovery is off
+	a.Config.Discovery.Mode = DiscoveryOff
+	if reader := a.SensorEventReader(r); reader.rediscover != nil {
+		t.Error("expected rediscovery to be disabled while discovery is off")
+	}
+}
@@ -1,11 +1,34 @@
 package deconz
 
+import (
+	"strconv"
+	"strings"
+)
+
 // Sensors is a map of sensors indexed by their id
 type Sensors map[int]Sensor
 
 // Sensor is a deCONZ sensor, not that we only implement fields needed
 // for event parsing to work
 type Sensor struct {
-	Type string
-	Name string
+	Type      string
+	Name      string
+	SWVersion string
+	Config    map[string]interface{}
+
+	// tags is the precomputed set of timeseries tags for this sensor,
+	// populated by CachedSensorStore so Timeseries can avoid allocating them per event
+	tags map[string]string
+}
+
+// timeseriesTags returns the tags identifying sensor id s in influxdb
+func (s *Sensor) timeseriesTags(id int) map[string]string {
+	return map[string]string{"name": trimBackslashes(s.Name), "type": trimBackslashes(s.Type), "id": strconv.Itoa(id)}
+}
+
+// trimBackslashes removes trailing backslashes from a tag value, the influx
+// client escapes commas, spaces and equal signs but leaves backslashes as they
+// are, so a trailing one would escape the separator following the tag
+func trimBackslashes(s string) string {
+	return strings.TrimRight(s, "\\")
 }
@@ -1,28 +1,330 @@
 package deconz
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
 )
 
 // SensorEvent is a sensor and a event embedded
 type SensorEvent struct {
 	*Sensor
 	*event.Event
+	options *TimeseriesOptions
+	// gatewaySWVersion is the firmware of the gateway the event was read from
+	gatewaySWVersion string
+	// connection counts the websocket connections of the reader, starting at 1
+	connection int
+	// sequence counts the events of the sensor read, starting at 1
+	sequence int64
+}
+
+// TimeseriesOptions configures the optional tags and fields returned by Timeseries
+type TimeseriesOptions struct {
+	// ConfigTags whitelists sensor config keys which are added as tags prefixed
+	// with "config_", nested keys are flattened and joined with "_" e.g. "alert_on"
+	ConfigTags []string
+	// SWVersionTag adds the sensors firmware version as the tag "swversion"
+	SWVersionTag bool
+	// GatewaySWVersionTag adds the firmware version of the gateway as the tag
+	// "gateway_swversion", it is fetched again on every reconnect
+	GatewaySWVersionTag bool
+	// ConnectionTag adds the number of the connection to the gateway the event
+	// was read from as the tag "connection", 1 until the first reconnect
+	ConnectionTag bool
+	// SequenceField adds the field "sequence" counting the events read per
+	// sensor, so points written out of order by several workers can be ordered
+	SequenceField bool
+	// EventTag adds the event type e.g. "changed", "added" or "deleted" as the tag "event"
+	EventTag bool
+	// ResourceTag adds the resource of the event e.g. "sensors", "lights" or
+	// "groups" as the tag "resource"
+	ResourceTag bool
+	// RawField adds the raw json state, or config if the event has no state,
+	// as the string field "_raw"
+	RawField bool
+	// Normalization cleans up tag keys and values
+	Normalization TagNormalization
+	// IntegerFields are written as integers instead of floats, e.g. large
+	// energy counters which would lose precision as a float
+	IntegerFields []string
+	// NumericType is the type numeric fields are written as, NumericAuto
+	// (default) keeps the type of each field, IntegerFields take precedence
+	NumericType string
+	// TagPrefix and TagSuffix are added to every tag value after normalization,
+	// e.g. to namespace the tags of several tenants sharing a database
+	TagPrefix string
+	TagSuffix string
+	// EventTime writes points at the lastupdated time of their state instead
+	// of the time they were received
+	EventTime bool
+	// LastUpdatedTimezone is the timezone gateways report lastupdated in, e.g.
+	// Europe/Berlin, defaults to UTC
+	LastUpdatedTimezone string
+	lastUpdatedLocation *time.Location
+}
+
+// Validate returns an error if the options would write invalid line protocol
+func (o *TimeseriesOptions) Validate() error {
+	for _, affix := range []string{o.TagPrefix, o.TagSuffix} {
+		if strings.IndexFunc(affix, unicode.IsControl) >= 0 {
+			return fmt.Errorf("tag prefix and suffix must not contain control characters, got %q", affix)
+		}
+	}
+	if strings.HasSuffix(o.TagSuffix, "\\") {
+		return fmt.Errorf("tag suffix must not end with a backslash, it would escape the separator following the tag")
+	}
+	switch o.NumericType {
+	case "", NumericAuto, NumericFloat, NumericInt:
+	default:
+		return fmt.Errorf("unknown numeric type %q, use %s, %s or %s", o.NumericType, NumericAuto, NumericFloat, NumericInt)
+	}
+	if o.LastUpdatedTimezone != "" {
+		location, err := time.LoadLocation(o.LastUpdatedTimezone)
+		if err != nil {
+			return fmt.Errorf("invalid lastupdated timezone: %s", err)
+		}
+		o.lastUpdatedLocation = location
+	}
+	return nil
+}
+
+// Numeric types of fields, NumericAuto is the default
+const (
+	// NumericAuto writes every field with the type of its mapping
+	NumericAuto = "auto"
+	// NumericFloat writes every number as a float
+	NumericFloat = "float"
+	// NumericInt writes every number as an integer, rounding fractions
+	NumericInt = "int"
+)
+
+// maxRawFieldSize is the largest string field influxdb accepts, larger raw states are left out
+const maxRawFieldSize = 64 * 1024
+
+// extraTags reports if the cached name, type and id tags need to be copied to
+// add tags besides them or to normalize them
+func (o *TimeseriesOptions) extraTags() bool {
+	return o != nil && (len(o.ConfigTags) > 0 || o.SWVersionTag || o.GatewaySWVersionTag || o.ConnectionTag || o.EventTag || o.ResourceTag || o.Normalization.enabled() || o.TagPrefix != "" || o.TagSuffix != "")
 }
 
 type fielder interface {
 	Fields() map[string]interface{}
 }
 
+// integerer is implemented by states keeping integers beyond the precision of float64
+type integerer interface {
+	Integer(field string) (int64, bool)
+}
+
 // Timeseries returns tags and fields for use in influxdb
+// the returned tags may be shared between events from the same sensor and must not be modified
 func (s *SensorEvent) Timeseries() (map[string]string, map[string]interface{}, error) {
 	f, ok := s.Event.State.(fielder)
 	if !ok {
 		return nil, nil, fmt.Errorf("this event (%T:%s) has no time series data", s.State, s.Name)
 	}
 
-	return map[string]string{"name": s.Name, "type": s.Sensor.Type, "id": strconv.Itoa(s.Event.ID)}, f.Fields(), nil
+	tags := s.Sensor.tags
+	if tags == nil {
+		tags = s.Sensor.timeseriesTags(s.Event.ID)
+	}
+
+	if s.options.extraTags() {
+		tags = s.withExtraTags(tags)
+	}
+
+	fields := f.Fields()
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("this event (%T:%s) has no fields", s.State, s.Name)
+	}
+
+	if s.options != nil && s.options.NumericType != "" && s.options.NumericType != NumericAuto {
+		numericFields(fields, s.options.NumericType)
+	}
+
+	if s.options != nil && len(s.options.IntegerFields) > 0 {
+		s.integerFields(f, fields)
+	}
+
+	if s.options != nil && s.options.RawField {
+		s.addRawField(fields)
+	}
+
+	if s.options != nil && s.options.SequenceField && s.sequence > 0 {
+		fields["sequence"] = s.sequence
+	}
+
+	return tags, fields, nil
+}
+
+// numericFields converts every number in fields to numericType
+func numericFields(fields map[string]interface{}, numericType string) {
+	for field, value := range fields {
+		switch v := value.(type) {
+		case int:
+			if numericType == NumericFloat {
+				fields[field] = float64(v)
+			} else {
+				fields[field] = int64(v)
+			}
+		case int64:
+			if numericType == NumericFloat {
+				fields[field] = float64(v)
+			}
+		case float64:
+			if numericType == NumericInt && math.Abs(v) < math.MaxInt64 {
+				fields[field] = int64(math.Round(v))
+			}
+		}
+	}
+}
+
+// integerFields converts the configured fields to int64, fields which are not
+// integral are left as they are
+func (s *SensorEvent) integerFields(state fielder, fields map[string]interface{}) {
+	for _, field := range s.options.IntegerFields {
+		if i, ok := state.(integerer); ok {
+			if v, ok := i.Integer(field); ok {
+				fields[field] = v
+				continue
+			}
+		}
+		switch v := fields[field].(type) {
+		case int:
+			fields[field] = int64(v)
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+				fields[field] = int64(v)
+			}
+		}
+	}
+}
+
+// addRawField adds the raw state of the event to fields
+func (s *SensorEvent) addRawField(fields map[string]interface{}) {
+	raw := s.Event.RawState
+	if len(raw) == 0 {
+		raw = s.Event.Config
+	}
+	if len(raw) == 0 {
+		return
+	}
+
+	if len(raw) > maxRawFieldSize {
+		logging.Warnf("not adding _raw to event from %s, its %d bytes exceed the %d bytes allowed in a field", s.Name, len(raw), maxRawFieldSize)
+		return
+	}
+	fields["_raw"] = string(raw)
+}
+
+// withExtraTags returns a copy of tags with the optional tags enabled in options added
+func (s *SensorEvent) withExtraTags(tags map[string]string) map[string]string {
+	result := make(map[string]string, len(tags)+len(s.options.ConfigTags)+2)
+	for k, v := range tags {
+		result[k] = v
+	}
+
+	if s.options.SWVersionTag && s.Sensor.SWVersion != "" {
+		result["swversion"] = s.Sensor.SWVersion
+	}
+
+	if s.options.GatewaySWVersionTag && s.gatewaySWVersion != "" {
+		result["gateway_swversion"] = s.gatewaySWVersion
+	}
+
+	if s.options.ConnectionTag && s.connection > 0 {
+		result["connection"] = strconv.Itoa(s.connection)
+	}
+
+	if s.options.EventTag && s.Event.Event != "" {
+		result["event"] = s.Event.Event
+	}
+
+	if s.options.ResourceTag && s.Event.Resource != "" {
+		result["resource"] = s.Event.Resource
+	}
+
+	if len(s.options.ConfigTags) > 0 {
+		s.addConfigTags(result)
+	}
+
+	if s.options.Normalization.enabled() {
+		result = s.options.Normalization.normalizeTags(result)
+	}
+
+	if s.options.TagPrefix != "" || s.options.TagSuffix != "" {
+		for k, v := range result {
+			result[k] = s.options.TagPrefix + v + s.options.TagSuffix
+		}
+	}
+
+	return result
+}
+
+// SensorID returns the id of the sensor of the event, or the resource and id
+// e.g. lights/3 for other resources as their ids overlap with the ones of
+// sensors. Unlike the id tag it is never prefixed, suffixed or normalized, so
+// it keys state kept per sensor
+func (s *SensorEvent) SensorID() string {
+	if s.Event.Resource == "" || s.Event.Resource == "sensors" {
+		return strconv.Itoa(s.Event.ID)
+	}
+	return s.Event.Resource + "/" + strconv.Itoa(s.Event.ID)
+}
+
+// addConfigTags adds the whitelisted config values to tags, the config sent
+// along with the event is merged over the config known from the rest api, as
+// events only carry the keys that changed
+func (s *SensorEvent) addConfigTags(tags map[string]string) {
+	config := s.Sensor.Config
+	if len(s.Event.Config) > 0 {
+		var eventConfig map[string]interface{}
+		err := json.Unmarshal(s.Event.Config, &eventConfig)
+		if err == nil {
+			config = make(map[string]interface{}, len(s.Sensor.Config)+len(eventConfig))
+			for k, v := range s.Sensor.Config {
+				config[k] = v
+			}
+			for k, v := range eventConfig {
+				config[k] = v
+			}
+		}
+	}
+
+	flattened := make(map[string]string)
+	flattenConfig("", config, flattened)
+
+	for _, key := range s.options.ConfigTags {
+		if v, found := flattened[key]; found {
+			tags["config_"+key] = trimBackslashes(v)
+		}
+	}
+}
+
+// flattenConfig flattens nested config objects into out, joining keys with "_"
+func flattenConfig(prefix string, config map[string]interface{}, out map[string]string) {
+	for k, v := range config {
+		if prefix != "" {
+			k = prefix + "_" + k
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenConfig(k, v, out)
+		case string:
+			out[k] = v
+		case bool:
+			out[k] = strconv.FormatBool(v)
+		case float64:
+			out[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
 }
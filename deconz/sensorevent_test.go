@@ -0,0 +1,589 @@
+package deconz
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+type testSensorGetter struct {
+}
+
+func (t *testSensorGetter) Sensors() (*Sensors, error) {
+	return &Sensors{5: Sensor{Name: "Test Sensor", Type: "ZHAFire"}}, nil
+}
+
+func TestTimeseriesCachedTags(t *testing.T) {
+	store := CachedSensorStore{SensorGetter: &testSensorGetter{}}
+	sensor, err := store.LookupSensor(5)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	d := event.Decoder{TypeStore: &store}
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	cached, _, err := (&SensorEvent{Event: e, Sensor: sensor}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	uncached, _, err := (&SensorEvent{Event: e, Sensor: &Sensor{Name: "Test Sensor", Type: "ZHAFire"}}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	if len(cached) != len(uncached) {
+		t.Fail()
+	}
+	for k, v := range uncached {
+		if cached[k] != v {
+			t.Errorf("tag %s: expected %s, got %s", k, v, cached[k])
+		}
+	}
+}
+
+const temperatureConfigEventPayload = `{"e":"changed","id":"1","r":"sensors","config":{"battery":100,"offset":-50,"on":true,"alert":{"on":false}},"t":"event"}`
+
+func TestTimeseriesConfigTags(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	options := &TimeseriesOptions{ConfigTags: []string{"offset", "alert_on", "missing"}}
+
+	// config from the rest api is used when the event carries none
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	sensor := &Sensor{Name: "Test Sensor", Type: "ZHAFire", Config: map[string]interface{}{"offset": float64(10), "on": true}}
+	tags, _, err := (&SensorEvent{Event: e, Sensor: sensor, options: options}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["config_offset"] != "10" {
+		t.Errorf("expected config_offset 10, got %s", tags["config_offset"])
+	}
+	if _, found := tags["config_on"]; found {
+		t.Error("config_on is not whitelisted")
+	}
+	if tags["name"] != "Test Sensor" {
+		t.Fail()
+	}
+
+	// config sent with the event is preferred
+	e, err = d.Parse([]byte(temperatureConfigEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	tags, _, err = (&SensorEvent{Event: e, Sensor: sensor, options: options}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["config_offset"] != "-50" {
+		t.Errorf("expected config_offset -50, got %s", tags["config_offset"])
+	}
+	if tags["config_alert_on"] != "false" {
+		t.Errorf("expected config_alert_on false, got %s", tags["config_alert_on"])
+	}
+	if _, found := tags["config_missing"]; found {
+		t.Error("config_missing should not be present")
+	}
+}
+
+const temperaturePartialConfigEventPayload = `{"e":"changed","id":"1","r":"sensors","config":{"battery":90},"t":"event"}`
+
+func TestTimeseriesConfigTagsPartialConfig(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	e, err := d.Parse([]byte(temperaturePartialConfigEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	// the keys the event does not repeat are kept from the rest api
+	options := &TimeseriesOptions{ConfigTags: []string{"on", "alert", "battery"}}
+	sensor := &Sensor{Name: "Test Sensor", Type: "ZHATemperature", Config: map[string]interface{}{"on": true, "alert": "none", "battery": float64(100)}}
+	tags, _, err := (&SensorEvent{Event: e, Sensor: sensor, options: options}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["config_on"] != "true" || tags["config_alert"] != "none" || tags["config_battery"] != "90" {
+		t.Errorf("expected the event config to be merged over the rest api config, got %v", tags)
+	}
+	if sensor.Config["battery"] != float64(100) {
+		t.Errorf("expected the config of the sensor to be left as it is, got %v", sensor.Config)
+	}
+}
+
+func TestTimeseriesSWVersionTag(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	sensor := &Sensor{Name: "Test Sensor", Type: "ZHAFire", SWVersion: "20170627"}
+
+	tags, _, err := (&SensorEvent{Event: e, Sensor: sensor}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if _, found := tags["swversion"]; found {
+		t.Error("swversion should be off by default")
+	}
+
+	tags, _, err = (&SensorEvent{Event: e, Sensor: sensor, options: &TimeseriesOptions{SWVersionTag: true}}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["swversion"] != "20170627" {
+		t.Errorf("expected swversion 20170627, got %s", tags["swversion"])
+	}
+}
+
+func TestTimeseriesEventTag(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	sensor := &Sensor{Name: "Test Sensor", Type: "ZHAFire"}
+
+	tags, _, err := (&SensorEvent{Event: e, Sensor: sensor}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if _, found := tags["event"]; found {
+		t.Error("event should be off by default")
+	}
+
+	tags, _, err = (&SensorEvent{Event: e, Sensor: sensor, options: &TimeseriesOptions{EventTag: true}}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["event"] != "changed" {
+		t.Errorf("expected event changed, got %s", tags["event"])
+	}
+}
+
+func TestTimeseriesResourceTag(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	sensor := &Sensor{Name: "Test Sensor", Type: "ZHAFire"}
+
+	tags, _, err := (&SensorEvent{Event: e, Sensor: sensor}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if _, found := tags["resource"]; found {
+		t.Error("resource should be off by default")
+	}
+
+	tags, _, err = (&SensorEvent{Event: e, Sensor: sensor, options: &TimeseriesOptions{ResourceTag: true}}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["resource"] != "sensors" {
+		t.Errorf("expected resource sensors, got %s", tags["resource"])
+	}
+}
+
+func TestTimeseriesRawField(t *testing.T) {
+	d := event.Decoder{TypeStore: typeLookup{6: "ZHAWater"}}
+	e, err := d.Parse([]byte(waterEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	sensor := &Sensor{Name: "Basement", Type: "ZHAWater"}
+
+	_, fields, err := (&SensorEvent{Event: e, Sensor: sensor}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if _, found := fields["_raw"]; found {
+		t.Error("_raw should be off by default")
+	}
+
+	_, fields, err = (&SensorEvent{Event: e, Sensor: sensor, options: &TimeseriesOptions{RawField: true}}).Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	expected := `{"lastupdated":"2018-03-13T20:46:03","lowbattery":false,"tampered":true,"water":true}`
+	if fields["_raw"] != expected {
+		t.Errorf("expected _raw %s, got %v", expected, fields["_raw"])
+	}
+}
+
+const airQualityEventPayload = `{"e":"changed","id":"8","r":"sensors","state":{"airquality":"good","airqualityppb":120,"lastupdated":"2021-01-01T12:00:00"},"t":"event"}`
+
+const vibrationEventPayload = `{"e":"changed","id":"9","r":"sensors","state":{"lastupdated":"2019-01-09T12:34:56","orientation":[1,-2,65],"tiltangle":72,"vibration":true,"vibrationstrength":36},"t":"event"}`
+
+const waterEventPayload = `{"e":"changed","id":"6","r":"sensors","state":{"lastupdated":"2018-03-13T20:46:03","lowbattery":false,"tampered":true,"water":true},"t":"event"}`
+
+const openCloseEventPayload = `{"e":"changed","id":"10","r":"sensors","state":{"lastupdated":"2019-05-01T08:00:00","open":true},"t":"event"}`
+
+const openCloseConfigEventPayload = `{"e":"changed","id":"10","r":"sensors","config":{"battery":87,"on":true,"reachable":true},"t":"event"}`
+
+const carbonMonoxideEventPayload = `{"e":"changed","id":"11","r":"sensors","state":{"carbonmonoxide":true,"lastupdated":"2020-02-02T02:02:02","lowbattery":false,"tampered":false,"test":true},"t":"event"}`
+
+const moistureEventPayload = `{"e":"changed","id":"13","r":"sensors","state":{"lastupdated":"2021-06-12T07:31:44.123","moisture":3125},"t":"event"}`
+
+const moistureConfigEventPayload = `{"e":"changed","id":"13","r":"sensors","config":{"battery":64,"on":true,"reachable":false},"t":"event"}`
+
+const consumptionEventPayload = `{"e":"changed","id":"12","r":"sensors","state":{"consumption":123456,"lastupdated":"2021-03-01T12:00:00","power":42},"t":"event"}`
+
+type typeLookup map[int]string
+
+func (t typeLookup) LookupType(i int) (string, error) {
+	return t[i], nil
+}
+
+func TestTimeseriesFieldTypes(t *testing.T) {
+	d := event.Decoder{TypeStore: typeLookup{5: "ZHAFire", 8: "ZHAAirQuality", 9: "ZHAVibration", 6: "ZHAWater", 10: "ZHAOpenClose", 11: "ZHACarbonMonoxide", 12: "ZHAConsumption", 13: "ZHAMoisture"}}
+
+	for _, c := range []struct {
+		payload  string
+		sensor   Sensor
+		types    map[string]string
+		protocol []string
+	}{
+		{
+			payload:  airQualityEventPayload,
+			sensor:   Sensor{Name: "Air", Type: "ZHAAirQuality"},
+			types:    map[string]string{"airquality": "string", "airqualityppb": "int"},
+			protocol: []string{`airquality="good"`, "airqualityppb=120i"},
+		},
+		{
+			payload:  smokeDetectorNoFireEventPayload,
+			sensor:   Sensor{Name: "Smoke", Type: "ZHAFire"},
+			types:    map[string]string{"fire": "bool", "lowbattery": "bool", "tampered": "bool", "test": "bool"},
+			protocol: []string{"fire=false", "lowbattery=false", "test=false"},
+		},
+		{
+			payload:  vibrationEventPayload,
+			sensor:   Sensor{Name: "Washer", Type: "ZHAVibration"},
+			types:    map[string]string{"vibration": "bool", "tiltangle": "int", "orientation_z": "int"},
+			protocol: []string{"vibration=true", "tiltangle=72i", "orientation_y=-2i"},
+		},
+		{
+			payload:  waterEventPayload,
+			sensor:   Sensor{Name: "Basement", Type: "ZHAWater"},
+			types:    map[string]string{"water": "bool", "lowbattery": "bool", "tampered": "bool"},
+			protocol: []string{"water=true", "lowbattery=false", "tampered=true"},
+		},
+		{
+			payload:  openCloseEventPayload,
+			sensor:   Sensor{Name: "Front door", Type: "ZHAOpenClose"},
+			types:    map[string]string{"open": "bool"},
+			protocol: []string{"open=true"},
+		},
+		{
+			payload:  openCloseConfigEventPayload,
+			sensor:   Sensor{Name: "Front door", Type: "ZHAOpenClose"},
+			types:    map[string]string{"battery": "int", "reachable": "bool"},
+			protocol: []string{"battery=87i", "reachable=true"},
+		},
+		{
+			payload:  carbonMonoxideEventPayload,
+			sensor:   Sensor{Name: "Boiler room", Type: "ZHACarbonMonoxide"},
+			types:    map[string]string{"CO": "bool", "lowbattery": "bool", "tampered": "bool", "test": "bool"},
+			protocol: []string{"CO=true", "tampered=false", "test=true"},
+		},
+		{
+			payload:  consumptionEventPayload,
+			sensor:   Sensor{Name: "Washer", Type: "ZHAConsumption"},
+			types:    map[string]string{"consumption": "int64", "power": "int"},
+			protocol: []string{"consumption=123456i", "power=42i"},
+		},
+		{
+			payload:  moistureEventPayload,
+			sensor:   Sensor{Name: "Tomatoes", Type: "ZHAMoisture"},
+			types:    map[string]string{"moisture": "float64"},
+			protocol: []string{"moisture=31.25"},
+		},
+		{
+			payload:  moistureConfigEventPayload,
+			sensor:   Sensor{Name: "Tomatoes", Type: "ZHAMoisture"},
+			types:    map[string]string{"battery": "int", "reachable": "bool"},
+			protocol: []string{"battery=64i", "reachable=false"},
+		},
+	} {
+		e, err := d.Parse([]byte(c.payload))
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+
+		sensor := c.sensor
+		tags, fields, err := (&SensorEvent{Event: e, Sensor: &sensor}).Timeseries()
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+
+		for field, expected := range c.types {
+			if actual := fmt.Sprintf("%T", fields[field]); actual != expected {
+				t.Errorf("%s: expected field %s to be %s, got %s", sensor.Type, field, expected, actual)
+			}
+		}
+
+		line := write.PointToLineProtocol(influxdb2.NewPoint("deflux_"+sensor.Type, tags, fields, time.Now()), time.Nanosecond)
+		for _, expected := range c.protocol {
+			if !strings.Contains(line, expected) {
+				t.Errorf("%s: expected %s in line protocol %s", sensor.Type, expected, line)
+			}
+		}
+	}
+}
+
+func benchmarkTimeseries(b *testing.B, sensor *Sensor) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := &SensorEvent{Event: e, Sensor: sensor}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := s.Timeseries()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTimeseries(b *testing.B) {
+	benchmarkTimeseries(b, &Sensor{Name: "Test Sensor", Type: "ZHAFire"})
+}
+
+func BenchmarkTimeseriesCachedSensor(b *testing.B) {
+	store := CachedSensorStore{SensorGetter: &testSensorGetter{}}
+	sensor, err := store.LookupSensor(5)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkTimeseries(b, sensor)
+}
+
+func TestTimeseriesNoFields(t *testing.T) {
+	d := event.Decoder{TypeStore: typeLookup{10: "ZHAOpenClose"}}
+	e, err := d.Parse([]byte(`{"e":"changed","id":"10","r":"sensors","config":{"on":true},"t":"event"}`))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	// a point without fields is rejected by influxdb
+	_, _, err = (&SensorEvent{Event: e, Sensor: &Sensor{Name: "Front door", Type: "ZHAOpenClose"}}).Timeseries()
+	if err == nil {
+		t.Error("expected an error for an event without fields")
+	}
+}
+
+func TestTimeseriesTagAffixes(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	parsed, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	e := &SensorEvent{
+		Event:  parsed,
+		Sensor: &Sensor{Name: "Kælder bad", Type: "ZHAFire"},
+		options: &TimeseriesOptions{
+			Normalization: TagNormalization{ReplaceSpaces: "_"},
+			TagPrefix:     "home ",
+			TagSuffix:     "-1",
+		},
+	}
+	tags, _, err := e.Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["name"] != "home Kælder_bad-1" || tags["type"] != "home ZHAFire-1" || tags["id"] != "home 5-1" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if _, found := tags["home name-1"]; found {
+		t.Error("expected keys to be left without prefix and suffix")
+	}
+	if id := e.SensorID(); id != "5" {
+		t.Errorf("expected the sensor id to be left without prefix and suffix, got %s", id)
+	}
+}
+
+func TestSensorEventSensorID(t *testing.T) {
+	for resource, expected := range map[string]string{"": "3", "sensors": "3", "lights": "lights/3", "groups": "groups/3"} {
+		e := SensorEvent{Event: &event.Event{Resource: resource, ID: 3}}
+		if id := e.SensorID(); id != expected {
+			t.Errorf("%q: expected %s, got %s", resource, expected, id)
+		}
+	}
+}
+
+func TestTimeseriesOptionsValidate(t *testing.T) {
+	for _, c := range []struct {
+		options TimeseriesOptions
+		valid   bool
+	}{
+		{TimeseriesOptions{}, true},
+		{TimeseriesOptions{TagPrefix: "tenant=a,", TagSuffix: " b"}, true},
+		{TimeseriesOptions{TagPrefix: "a\n"}, false},
+		{TimeseriesOptions{TagSuffix: "\t"}, false},
+		{TimeseriesOptions{TagSuffix: "a\\"}, false},
+	} {
+		err := c.options.Validate()
+		if c.valid && err != nil {
+			t.Errorf("unexpected error validating %+v: %s", c.options, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("expected an error validating %+v", c.options)
+		}
+	}
+}
+
+func TestTimeseriesIntegerFields(t *testing.T) {
+	d := event.Decoder{TypeStore: typeLookup{12: "ZHAConsumption"}}
+
+	// 2^53 + 1 can not be represented as a float64
+	resource, err := d.Parse([]byte(`{"e":"changed","id":"4","r":"meters","state":{"consumption":9007199254740993,"voltage":230.5,"level":3},"t":"event"}`))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	e := &SensorEvent{Event: resource, Sensor: &Sensor{Name: "meters/4", Type: "meters"}, options: &TimeseriesOptions{IntegerFields: []string{"consumption", "voltage", "level"}}}
+	_, fields, err := e.Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if fields["consumption"] != int64(9007199254740993) || fields["level"] != int64(3) {
+		t.Errorf("expected integers to be kept exactly, got %v", fields)
+	}
+	if fields["voltage"] != 230.5 {
+		t.Errorf("expected fractions to be left as floats, got %v", fields["voltage"])
+	}
+
+	e.options = nil
+	_, fields, _ = e.Timeseries()
+	if fields["level"] != float64(3) {
+		t.Errorf("expected numbers to be floats by default, got %T", fields["level"])
+	}
+
+	parsed, err := d.Parse([]byte(consumptionEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	e = &SensorEvent{Event: parsed, Sensor: &Sensor{Name: "Washer", Type: "ZHAConsumption"}, options: &TimeseriesOptions{IntegerFields: []string{"power"}}}
+	_, fields, _ = e.Timeseries()
+	if fields["power"] != int64(42) || fields["consumption"] != int64(123456) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestTimeseriesNumericType(t *testing.T) {
+	d := event.Decoder{TypeStore: typeLookup{12: "ZHAConsumption", 1: "ZHATemperature"}}
+	parsed, err := d.Parse([]byte(consumptionEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	temperature, err := d.Parse([]byte(`{"e":"changed","id":"1","r":"sensors","state":{"temperature":2156},"t":"event"}`))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	for _, c := range []struct {
+		event    *event.Event
+		options  *TimeseriesOptions
+		expected map[string]interface{}
+	}{
+		{parsed, &TimeseriesOptions{NumericType: NumericFloat}, map[string]interface{}{"power": float64(42), "consumption": float64(123456)}},
+		{parsed, &TimeseriesOptions{NumericType: NumericFloat, IntegerFields: []string{"consumption"}}, map[string]interface{}{"power": float64(42), "consumption": int64(123456)}},
+		{temperature, &TimeseriesOptions{NumericType: NumericAuto}, map[string]interface{}{"temperature": 21.56}},
+		{temperature, &TimeseriesOptions{NumericType: NumericInt}, map[string]interface{}{"temperature": int64(22)}},
+	} {
+		_, fields, err := (&SensorEvent{Event: c.event, Sensor: &Sensor{Name: "Test", Type: "test"}, options: c.options}).Timeseries()
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		for field, expected := range c.expected {
+			if fields[field] != expected {
+				t.Errorf("%+v: expected %s to be %T(%v), got %T(%v)", c.options, field, expected, expected, fields[field], fields[field])
+			}
+		}
+	}
+
+	if err := (&TimeseriesOptions{NumericType: "decimal"}).Validate(); err == nil {
+		t.Error("expected an error for an unknown numeric type")
+	}
+}
+
+func TestTimeseriesLineProtocolEscaping(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	for _, c := range []struct {
+		name     string
+		options  *TimeseriesOptions
+		expected string
+	}{
+		{"Kitchen, Sink=1", nil, `name=Kitchen\,\ Sink\=1,`},
+		{`Drawer\`, nil, `name=Drawer,`},
+		{`Drawer\\`, nil, `name=Drawer,`},
+		{"Kitchen, Sink=1", &TimeseriesOptions{Normalization: TagNormalization{ReplaceSpecial: "_"}}, `name=Kitchen__Sink_1,`},
+	} {
+		tags, fields, err := (&SensorEvent{Event: e, Sensor: &Sensor{Name: c.name, Type: "ZHAFire"}, options: c.options}).Timeseries()
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+
+		line := write.PointToLineProtocol(influxdb2.NewPoint("deflux_ZHAFire", tags, fields, time.Unix(0, 0)), time.Nanosecond)
+		if !strings.Contains(line, c.expected) {
+			t.Errorf("%q: expected %s in line protocol %s", c.name, c.expected, line)
+		}
+		if !strings.Contains(line, ",type=ZHAFire ") {
+			t.Errorf("%q: separator after name was escaped in line protocol %s", c.name, line)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package deconz
 
 import (
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
 )
 
 // SensorLookup represents an interface for sensor lookup
@@ -20,15 +23,83 @@ type EventReader interface {
 	Close() error
 }
 
+// Policies for events read while the event buffer is full
+const (
+	// PolicyBlock waits for a writer to take an event, which holds up reading
+	PolicyBlock = "block"
+	// PolicyDropNewest drops the event read
+	PolicyDropNewest = "drop-newest"
+	// PolicyDropOldest drops the oldest event buffered to make room
+	PolicyDropOldest = "drop-oldest"
+)
+
+// ValidateFullPolicy returns an error if policy is unknown or drops events
+// without an event buffer of buffer events, an unbuffered channel is never
+// full so drop-newest would drop every event a writer is not waiting for
+// and drop-oldest would block
+func ValidateFullPolicy(policy string, buffer int) error {
+	switch policy {
+	case "", PolicyBlock:
+		return nil
+	case PolicyDropNewest, PolicyDropOldest:
+		if buffer <= 0 {
+			return fmt.Errorf("the %s policy needs an event buffer, set eventbuffer to a positive size", policy)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q for a full event buffer, must be one of %s, %s or %s", policy, PolicyBlock, PolicyDropNewest, PolicyDropOldest)
+}
+
+// ConnectionObserver is notified whenever the connection to deCONZ is established or lost
+type ConnectionObserver interface {
+	Connected()
+	Disconnected(uptime time.Duration)
+}
+
+// Quarantiner receives the frames dropped as they could not be parsed, e.g.
+// events of sensor types without a mapping
+type Quarantiner interface {
+	Quarantine(payload []byte, err error)
+}
+
 // SensorEventReader reads events from an event.reader and returns SensorEvents
 type SensorEventReader struct {
-	lookup  SensorLookup
-	reader  EventReader
-	running bool
+	// Observer is notified about connection changes if set
+	Observer ConnectionObserver
+	// Quarantine receives the frames that could not be parsed if set
+	Quarantine Quarantiner
+
+	lookup               SensorLookup
+	reader               EventReader
+	options              *TimeseriesOptions
+	maxReconnectsPerHour int
+	reconnect            ReconnectOptions
+	resources            []string
+	connections          int
+	reconnects           []time.Time
+	parseErrors          uint64
+	// gatewayVersion fetches the firmware version of the gateway on every
+	// connection if set, it is only accessed by the reading goroutine
+	gatewayVersion   func() (string, error)
+	gatewaySWVersion string
+	// snapshot fetches the state of every sensor once connected if set
+	snapshot func() (map[int]json.RawMessage, error)
+	// rediscover points the reader at the new address of a gateway that
+	// moved if set, it is called after reconnect.RediscoverAfter failures
+	rediscover func() error
+	// sequences counts the events sent per SensorID, it is only accessed by
+	// the reading goroutine
+	sequences map[string]int64
+	// fullPolicy is applied when out is full, droppedEvents is accessed atomically
+	fullPolicy    string
+	droppedEvents uint64
+	// running is accessed atomically as StopReadEvents is called from other goroutines
+	running int32
 }
 
 // starts a thread reading events into the given channel
-// returns immediately
+// the first connection is established before returning, so an unreachable
+// gateway is reported to the caller instead of being retried
 func (r *SensorEventReader) Start(out chan *SensorEvent) error {
 
 	if r.lookup == nil {
@@ -38,59 +109,227 @@ func (r *SensorEventReader) Start(out chan *SensorEvent) error {
 		return errors.New("Cannot run without a EventReader from which to read events")
 	}
 
-	if r.running {
+	if r.isRunning() {
 		return errors.New("Reader is already running.")
 	}
 
-	r.running = true
+	err := ValidateFullPolicy(r.fullPolicy, cap(out))
+	if err != nil {
+		return err
+	}
+
+	err = r.reconnect.retry("connecting to deconz", r.reader.Dial)
+	if err != nil {
+		return fmt.Errorf("unable to connect: %s", err)
+	}
+	logging.Infof("Deconz websocket connected")
+
+	atomic.StoreInt32(&r.running, 1)
 
 	go func() {
+		dialed := true
 	REDIAL:
-		for r.running {
+		for r.isRunning() {
 			// establish connection
-			for r.running {
+			failures := 0
+			for r.isRunning() && !dialed {
 				err := r.reader.Dial()
 				if err != nil {
-					log.Printf("Error connecting Deconz websocket: %s\nAttempting reconnect in 5s...", err)
-					time.Sleep(5 * time.Second) // TODO configurable delay
+					failures++
+					if r.rediscover != nil && failures%r.reconnect.RediscoverAfter == 0 {
+						rerr := r.rediscover()
+						if rerr == nil {
+							continue
+						}
+						logging.Warnf("Rediscovering the gateway failed: %s", rerr)
+					}
+					logging.Warnf("Error connecting Deconz websocket: %s\nAttempting reconnect in %s...", err, r.reconnect.delay())
+					time.Sleep(r.reconnect.delay())
 				} else {
-					log.Printf("Deconz websocket connected")
+					logging.Infof("Deconz websocket connected")
 					break
 				}
 			}
+			dialed = false
+			connectedAt := time.Now()
+			r.connected(connectedAt)
+			if r.connections == 1 && r.snapshot != nil && r.forwards("sensors") {
+				r.sendSnapshot(out)
+			}
 			// read events until connection fails
-			for r.running {
+			for r.isRunning() {
 				e, err := r.reader.ReadEvent()
 				if err != nil {
 					if eerr, ok := err.(event.EventError); ok && eerr.Recoverable() {
-						log.Printf("Dropping event due to error: %s", err)
+						atomic.AddUint64(&r.parseErrors, 1)
+						logging.Debugf("Dropping event due to error: %s", err)
+						r.quarantine(err)
 						continue
 					}
+					logging.Warnf("Deconz websocket connection lost after %s: %s", time.Since(connectedAt), err)
+					r.disconnected(connectedAt)
 					continue REDIAL
 				}
-				// we only care about sensor events
-				if e.Resource != "sensors" {
-					log.Printf("Dropping non-sensor event type %s", e.Resource)
+				// we only care about the configured resources
+				if !r.forwards(e.Resource) {
+					logging.Debugf("Dropping %s event", e.Resource)
+					continue
+				}
+				if _, ok := e.State.(*event.IgnoredState); ok {
+					logging.Debugf("Dropping event of ignored sensor %d", e.ID)
 					continue
 				}
 
-				sensor, err := r.lookup.LookupSensor(e.ID)
+				sensor, err := r.resource(e)
 				if err != nil {
-					log.Printf("Dropping event. Could not lookup sensor for id %d: %s", e.ID, err)
+					logging.Warnf("Dropping event. Could not lookup sensor for id %d: %s", e.ID, err)
 					continue
 				}
 				// send event on channel
-				out <- &SensorEvent{Event: e, Sensor: sensor}
+				r.send(out, &SensorEvent{Event: e, Sensor: sensor, options: r.options, gatewaySWVersion: r.gatewaySWVersion, connection: r.connections})
 			}
 		}
 		// if not running, close connection and return from goroutine
 		r.reader.Close()
-		log.Printf("Deconz websocket closed")
+		logging.Infof("Deconz websocket closed")
 	}()
 	return nil
 }
 
+// quarantine hands the frame err occurred on to the Quarantiner, if both are set
+func (r *SensorEventReader) quarantine(err error) {
+	perr, ok := err.(event.EventErrorImpl)
+	if !ok || r.Quarantine == nil || len(perr.Payload()) == 0 {
+		return
+	}
+	r.Quarantine.Quarantine(perr.Payload(), perr)
+}
+
+// send sends e on out, applying the full policy if out is full
+func (r *SensorEventReader) send(out chan *SensorEvent, e *SensorEvent) {
+	if r.options != nil && r.options.SequenceField {
+		if r.sequences == nil {
+			r.sequences = make(map[string]int64)
+		}
+		r.sequences[e.SensorID()]++
+		e.sequence = r.sequences[e.SensorID()]
+	}
+
+	if r.fullPolicy == "" || r.fullPolicy == PolicyBlock {
+		out <- e
+		return
+	}
+
+	select {
+	case out <- e:
+		return
+	default:
+	}
+
+	atomic.AddUint64(&r.droppedEvents, 1)
+	if r.fullPolicy == PolicyDropNewest {
+		logging.Debugf("Dropping event of %s, the event buffer is full", e.Sensor.Name)
+		return
+	}
+
+	// make room by dropping the oldest event, unless a writer just did
+	select {
+	case old := <-out:
+		logging.Debugf("Dropping event of %s, the event buffer is full", old.Sensor.Name)
+	default:
+	}
+	out <- e
+}
+
+// forwards reports if events of resource are read, only sensors are unless other resources are configured
+func (r *SensorEventReader) forwards(resource string) bool {
+	if len(r.resources) == 0 {
+		return resource == "sensors"
+	}
+	for _, res := range r.resources {
+		if res == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// resource returns the sensor of a sensor event, events of other resources are
+// described by a sensor typed after the resource as only sensors are looked up
+func (r *SensorEventReader) resource(e *event.Event) (*Sensor, error) {
+	if e.Resource == "sensors" {
+		return r.lookup.LookupSensor(e.ID)
+	}
+	return &Sensor{Name: fmt.Sprintf("%s/%d", e.Resource, e.ID), Type: e.Resource}, nil
+}
+
+func (r *SensorEventReader) isRunning() bool {
+	return atomic.LoadInt32(&r.running) == 1
+}
+
+// FullPolicy returns the policy applied when the event buffer is full
+func (r *SensorEventReader) FullPolicy() string {
+	if r.fullPolicy == "" {
+		return PolicyBlock
+	}
+	return r.fullPolicy
+}
+
+// DroppedEvents returns the number of events dropped as the event buffer was full
+func (r *SensorEventReader) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&r.droppedEvents)
+}
+
+// ParseErrors returns the number of events dropped as they could not be parsed
+func (r *SensorEventReader) ParseErrors() uint64 {
+	return atomic.LoadUint64(&r.parseErrors)
+}
+
+// connected records a new connection, warning if deCONZ has been reconnected
+// to more often than maxReconnectsPerHour within the last hour
+func (r *SensorEventReader) connected(at time.Time) {
+	r.connections++
+	if r.connections > 1 {
+		r.reconnected(at)
+	}
+
+	// the gateway might have been updated while disconnected
+	if r.gatewayVersion != nil {
+		version, err := r.gatewayVersion()
+		if err != nil {
+			logging.Warnf("unable to fetch the gateway firmware version, keeping %q: %s", r.gatewaySWVersion, err)
+		} else {
+			r.gatewaySWVersion = version
+		}
+	}
+
+	if r.Observer != nil {
+		r.Observer.Connected()
+	}
+}
+
+func (r *SensorEventReader) reconnected(at time.Time) {
+	// only keep the reconnects from the last hour around
+	recent := r.reconnects[:0]
+	for _, t := range r.reconnects {
+		if at.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	r.reconnects = append(recent, at)
+
+	if r.maxReconnectsPerHour > 0 && len(r.reconnects) > r.maxReconnectsPerHour {
+		logging.Warnf("Reconnected to deCONZ %d times within the last hour, the network or gateway might be unstable", len(r.reconnects))
+	}
+}
+
+func (r *SensorEventReader) disconnected(connectedAt time.Time) {
+	if r.Observer != nil {
+		r.Observer.Disconnected(time.Since(connectedAt))
+	}
+}
+
 // Close closes the reader, closing the connection to deconz and terminating the goroutine
 func (r *SensorEventReader) StopReadEvents() {
-	r.running = false
+	atomic.StoreInt32(&r.running, 0)
 }
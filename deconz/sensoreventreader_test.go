@@ -1,8 +1,10 @@
 package deconz
 
 import (
+	"errors"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/dfuchslin/deflux/deconz/event"
 )
@@ -33,13 +35,31 @@ func (t testReader) Dial() error {
 func (t testReader) Close() error {
 	return nil
 }
+
+type unreachableReader struct {
+	testReader
+}
+
+func (t unreachableReader) Dial() error {
+	return errors.New("connection refused")
+}
+
+func TestSensorEventReaderUnreachable(t *testing.T) {
+	r := SensorEventReader{lookup: &testLookup{}, reader: unreachableReader{}, reconnect: ReconnectOptions{Attempts: 2, Delay: time.Millisecond}}
+	err := r.Start(make(chan *SensorEvent))
+	if err == nil {
+		t.Error("expected an unreachable gateway to be reported")
+	}
+}
+
 func TestSensorEventReader(t *testing.T) {
 
-	r := SensorEventReader{reader: testReader{}}
+	r := SensorEventReader{lookup: &testLookup{}, reader: testReader{}}
 	channel := make(chan *SensorEvent)
 	err := r.Start(channel)
 	if err != nil {
-		t.Fail()
+		t.Logf(err.Error())
+		t.FailNow()
 	}
 	e := <-channel
 	if strconv.Itoa(e.Event.ID) != "5" {
@@ -62,3 +82,324 @@ func TestSensorEventReader(t *testing.T) {
 	}
 
 }
+
+type disconnectingReader struct {
+	testReader
+}
+
+func (t disconnectingReader) ReadEvent() (*event.Event, error) {
+	return nil, errors.New("connection reset")
+}
+
+type testObserver struct {
+	connected    chan bool
+	disconnected chan time.Duration
+}
+
+func (t *testObserver) Connected() {
+	t.connected <- true
+}
+
+func (t *testObserver) Disconnected(uptime time.Duration) {
+	t.disconnected <- uptime
+}
+
+func TestSensorEventReaderReconnects(t *testing.T) {
+	observer := &testObserver{connected: make(chan bool), disconnected: make(chan time.Duration)}
+	r := SensorEventReader{lookup: &testLookup{}, reader: disconnectingReader{}, Observer: observer, maxReconnectsPerHour: 1}
+	err := r.Start(make(chan *SensorEvent))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	// the reader blocks on the observer, so it is safe to look at it in between
+	for i := 0; i < 3; i++ {
+		<-observer.connected
+		if len(r.reconnects) != i {
+			t.Errorf("expected %d reconnects within the hour, got %d", i, len(r.reconnects))
+		}
+		if i == 2 {
+			r.StopReadEvents()
+		}
+		<-observer.disconnected
+	}
+}
+
+type recoverableError struct {
+	error
+}
+
+func (e recoverableError) Recoverable() bool {
+	return true
+}
+
+// garbageReader returns a malformed frame before every valid one
+type garbageReader struct {
+	testReader
+	frames int
+}
+
+func (t *garbageReader) ReadEvent() (*event.Event, error) {
+	t.frames++
+	if t.frames%2 == 1 {
+		d := event.Decoder{TypeStore: &testLookup{}}
+		_, err := d.Parse([]byte("\x00{not json"))
+		return nil, recoverableError{err}
+	}
+	return t.testReader.ReadEvent()
+}
+
+func TestSensorEventReaderMalformedFrames(t *testing.T) {
+	r := SensorEventReader{lookup: &testLookup{}, reader: &garbageReader{}}
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	// reading continues after the garbage
+	for i := 0; i < 2; i++ {
+		e := <-channel
+		if e.Event.ID != 5 {
+			t.Errorf("unexpected event %v", e.Event)
+		}
+	}
+	r.StopReadEvents()
+
+	if r.ParseErrors() < 2 {
+		t.Errorf("expected at least 2 parse errors, got %d", r.ParseErrors())
+	}
+}
+
+type lightReader struct {
+	testReader
+}
+
+func (t lightReader) ReadEvent() (*event.Event, error) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	return d.Parse([]byte(`{"e":"changed","id":"3","r":"lights","state":{"on":true},"t":"event"}`))
+}
+
+func TestSensorEventReaderResources(t *testing.T) {
+	if !(&SensorEventReader{}).forwards("sensors") || (&SensorEventReader{}).forwards("lights") {
+		t.Error("expected only sensors to be forwarded by default")
+	}
+
+	r := SensorEventReader{lookup: &testLookup{}, reader: lightReader{}, resources: []string{"sensors", "lights"}}
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	e := <-channel
+	r.StopReadEvents()
+
+	tags, fields, err := e.Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["type"] != "lights" || tags["name"] != "lights/3" || tags["id"] != "3" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if fields["on"] != true {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+type ignoredLookup struct {
+	testLookup
+}
+
+func (t *ignoredLookup) LookupType(i int) (string, error) {
+	if i == 1 {
+		return "Configuration tool", nil
+	}
+	return "ZHAFire", nil
+}
+
+// configurationToolReader sends an event of the configuration tool before every smoke detector event
+type configurationToolReader struct {
+	testReader
+	reads int
+}
+
+func (t *configurationToolReader) ReadEvent() (*event.Event, error) {
+	t.reads++
+	d := event.Decoder{TypeStore: &ignoredLookup{}}
+	if t.reads%2 == 1 {
+		return d.Parse([]byte(`{"e":"changed","id":"1","r":"sensors","state":{"lastupdated":"2021-03-01T12:00:00"},"t":"event"}`))
+	}
+	return d.Parse([]byte(smokeDetectorNoFireEventPayload))
+}
+
+func TestSensorEventReaderIgnoresConfigurationTool(t *testing.T) {
+	r := SensorEventReader{lookup: &testLookup{}, reader: &configurationToolReader{}}
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	e := <-channel
+	r.StopReadEvents()
+
+	if e.Event.ID != 5 {
+		t.Errorf("expected the configuration tool event to be dropped, got event of %d", e.Event.ID)
+	}
+}
+
+func TestSensorEventReaderGatewayVersion(t *testing.T) {
+	versions := []string{"2.11.05", "2.12.01"}
+	fetches := 0
+	r := SensorEventReader{
+		lookup:  &testLookup{},
+		reader:  testReader{},
+		options: &TimeseriesOptions{GatewaySWVersionTag: true},
+		gatewayVersion: func() (string, error) {
+			if fetches >= len(versions) {
+				return "", errors.New("unreachable")
+			}
+			fetches++
+			return versions[fetches-1], nil
+		},
+	}
+
+	// every connection fetches the version again, failing keeps the last one
+	for _, expected := range []string{"2.11.05", "2.12.01", "2.12.01"} {
+		r.connected(time.Now())
+		if r.gatewaySWVersion != expected {
+			t.Errorf("expected gateway version %s, got %s", expected, r.gatewaySWVersion)
+		}
+	}
+
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	e := <-channel
+	r.StopReadEvents()
+
+	tags, _, err := e.Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if tags["gateway_swversion"] != "2.12.01" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
+
+func TestSensorEventReaderFullPolicy(t *testing.T) {
+	first := &SensorEvent{Sensor: &Sensor{Name: "first"}}
+	second := &SensorEvent{Sensor: &Sensor{Name: "second"}}
+
+	for policy, expected := range map[string]string{PolicyDropNewest: "first", PolicyDropOldest: "second"} {
+		r := SensorEventReader{fullPolicy: policy}
+		channel := make(chan *SensorEvent, 1)
+		r.send(channel, first)
+		r.send(channel, second)
+
+		if e := <-channel; e.Sensor.Name != expected {
+			t.Errorf("%s: expected the %s event to be kept, got %s", policy, expected, e.Sensor.Name)
+		}
+		if r.DroppedEvents() != 1 {
+			t.Errorf("%s: expected 1 dropped event, got %d", policy, r.DroppedEvents())
+		}
+	}
+
+	r := SensorEventReader{lookup: &testLookup{}, reader: testReader{}, fullPolicy: "drop-everything"}
+	if err := r.Start(make(chan *SensorEvent)); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestValidateFullPolicy(t *testing.T) {
+	for _, c := range []struct {
+		policy string
+		buffer int
+		valid  bool
+	}{
+		{"", 0, true},
+		{PolicyBlock, 0, true},
+		{PolicyDropNewest, 1, true},
+		{PolicyDropOldest, 100, true},
+		{PolicyDropNewest, 0, false},
+		{PolicyDropOldest, 0, false},
+		{"drop-everything", 100, false},
+	} {
+		err := ValidateFullPolicy(c.policy, c.buffer)
+		if (err == nil) != c.valid {
+			t.Errorf("%s with a buffer of %d: expected valid %t, got %v", c.policy, c.buffer, c.valid, err)
+		}
+	}
+
+	// the policy is checked against the channel events are read into
+	r := SensorEventReader{lookup: &testLookup{}, reader: testReader{}, fullPolicy: PolicyDropOldest}
+	if err := r.Start(make(chan *SensorEvent)); err == nil {
+		t.Error("expected drop-oldest to be rejected on an unbuffered channel")
+	}
+}
+
+// flakyReader loses the connection after every event
+type flakyReader struct {
+	testReader
+	reads int
+}
+
+func (t *flakyReader) ReadEvent() (*event.Event, error) {
+	t.reads++
+	if t.reads%2 == 0 {
+		return nil, errors.New("connection reset")
+	}
+	return t.testReader.ReadEvent()
+}
+
+func TestSensorEventReaderConnectionTag(t *testing.T) {
+	r := SensorEventReader{lookup: &testLookup{}, reader: &flakyReader{}, options: &TimeseriesOptions{ConnectionTag: true}, reconnect: ReconnectOptions{Delay: time.Millisecond}}
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	for _, expected := range []string{"1", "2", "3"} {
+		tags, _, err := (<-channel).Timeseries()
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		if tags["connection"] != expected {
+			t.Errorf("expected connection %s, got %s", expected, tags["connection"])
+		}
+	}
+	r.StopReadEvents()
+}
+
+func TestSensorEventReaderSequenceField(t *testing.T) {
+	r := SensorEventReader{lookup: &testLookup{}, reader: &testReader{}, options: &TimeseriesOptions{SequenceField: true}}
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	for _, expected := range []int64{1, 2, 3} {
+		_, fields, err := (<-channel).Timeseries()
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		if fields["sequence"] != expected {
+			t.Errorf("expected sequence %d, got %v", expected, fields["sequence"])
+		}
+	}
+	r.StopReadEvents()
+}
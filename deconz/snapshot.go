@@ -0,0 +1,64 @@
+package deconz
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// sensorType looks up the type of a single known sensor
+type sensorType string
+
+func (t sensorType) LookupType(int) (string, error) {
+	return string(t), nil
+}
+
+// sendSnapshot sends an event per sensor with the state fetched from the
+// gateway, sensors without a known type or a state they were updated with are skipped
+func (r *SensorEventReader) sendSnapshot(out chan *SensorEvent) {
+	states, err := r.snapshot()
+	if err != nil {
+		logging.Warnf("unable to fetch the sensor states for a snapshot: %s", err)
+		return
+	}
+
+	ids := make([]int, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	sent := 0
+	for _, id := range ids {
+		e, sensor, ok := r.snapshotEvent(id, states[id])
+		if !ok {
+			continue
+		}
+		r.send(out, &SensorEvent{Event: e, Sensor: sensor, options: r.options, gatewaySWVersion: r.gatewaySWVersion, connection: r.connections})
+		sent++
+	}
+	logging.Infof("Sent a snapshot of %d sensors", sent)
+}
+
+// snapshotEvent returns a changed event of sensor id holding state
+func (r *SensorEventReader) snapshotEvent(id int, state json.RawMessage) (*event.Event, *Sensor, bool) {
+	sensor, err := r.lookup.LookupSensor(id)
+	if err != nil {
+		logging.Debugf("not adding sensor %d to the snapshot: %s", id, err)
+		return nil, nil, false
+	}
+
+	e := &event.Event{Type: "event", Event: "changed", Resource: "sensors", ID: id, RawState: state}
+	err = e.ParseState(sensorType(sensor.Type))
+	if err != nil {
+		logging.Debugf("not adding %s to the snapshot: %s", sensor.Name, err)
+		return nil, nil, false
+	}
+	if u, ok := e.State.(lastUpdater); !ok || u.LastUpdated() == "" || u.LastUpdated() == noLastUpdated {
+		logging.Debugf("not adding %s to the snapshot, it never reported a state", sensor.Name)
+		return nil, nil, false
+	}
+	return e, sensor, true
+}
@@ -0,0 +1,44 @@
+package deconz
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSensorEventReaderSnapshot(t *testing.T) {
+	r := SensorEventReader{lookup: &testLookup{}, reader: testReader{}, snapshot: func() (map[int]json.RawMessage, error) {
+		return map[int]json.RawMessage{
+			3: json.RawMessage(`{"fire":true,"lastupdated":"2021-03-01T12:00:00"}`),
+			4: json.RawMessage(`{"fire":false,"lastupdated":"none"}`),
+			5: json.RawMessage(`{"fire":"maybe"}`),
+		}, nil
+	}}
+	channel := make(chan *SensorEvent)
+	err := r.Start(channel)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	snapshot := <-channel
+	e := <-channel
+	r.StopReadEvents()
+
+	_, fields, err := snapshot.Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if snapshot.Event.ID != 3 || fields["fire"] != true {
+		t.Errorf("expected the snapshot of sensor 3, got %d with %v", snapshot.Event.ID, fields)
+	}
+	// sensors without a usable state are skipped
+	_, fields, err = e.Timeseries()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if e.Event.ID != 5 || fields["fire"] != false {
+		t.Errorf("expected the event read from the websocket after the snapshot, got %d with %v", e.Event.ID, fields)
+	}
+}
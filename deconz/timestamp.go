@@ -0,0 +1,74 @@
+package deconz
+
+import (
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// lastUpdatedLayouts are the formats of lastupdated seen across firmware
+// versions. Fractional seconds are accepted after the seconds of any of them,
+// a timezone given by the gateway takes precedence over the configured one
+var lastUpdatedLayouts = []string{
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+}
+
+// noLastUpdated is reported by sensors which were never updated
+const noLastUpdated = "none"
+
+// lastUpdater is implemented by states reporting when they were last updated
+type lastUpdater interface {
+	LastUpdated() string
+}
+
+// location returns the timezone lastupdated is reported in
+func (o *TimeseriesOptions) location() *time.Location {
+	if o.lastUpdatedLocation != nil {
+		return o.lastUpdatedLocation
+	}
+	if o.LastUpdatedTimezone != "" {
+		// the options were not validated, an invalid timezone falls back to UTC
+		location, err := time.LoadLocation(o.LastUpdatedTimezone)
+		if err == nil {
+			return location
+		}
+	}
+	return time.UTC
+}
+
+// Timestamp returns the time the point of the event is written at, which is
+// received unless EventTime is set and the state reports when it was updated
+func (s *SensorEvent) Timestamp(received time.Time) time.Time {
+	if s.options == nil || !s.options.EventTime {
+		return received
+	}
+	state, ok := s.Event.State.(lastUpdater)
+	if !ok {
+		return received
+	}
+	updated, ok := parseLastUpdated(state.LastUpdated(), s.options.location())
+	if !ok {
+		if v := state.LastUpdated(); v != "" && v != noLastUpdated {
+			logging.Debugf("unable to parse lastupdated %q of %s, using the time received", v, s.Sensor.Name)
+		}
+		return received
+	}
+	return updated
+}
+
+// parseLastUpdated parses value in any of the lastupdated layouts, reading
+// it in location unless it has a timezone of its own
+func parseLastUpdated(value string, location *time.Location) (time.Time, bool) {
+	if value == "" || value == noLastUpdated {
+		return time.Time{}, false
+	}
+	for _, layout := range lastUpdatedLayouts {
+		updated, err := time.ParseInLocation(layout, value, location)
+		if err == nil {
+			return updated, true
+		}
+	}
+	return time.Time{}, false
+}
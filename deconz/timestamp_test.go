@@ -0,0 +1,79 @@
+package deconz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+)
+
+func TestTimestamp(t *testing.T) {
+	d := event.Decoder{TypeStore: &testLookup{}}
+	e, err := d.Parse([]byte(smokeDetectorNoFireEventPayload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	received := time.Date(2018, 3, 13, 20, 0, 0, 0, time.UTC)
+
+	berlin := &TimeseriesOptions{EventTime: true, LastUpdatedTimezone: "Europe/Berlin"}
+	err = berlin.Validate()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	for _, c := range []struct {
+		options  *TimeseriesOptions
+		expected time.Time
+	}{
+		{nil, received},
+		{&TimeseriesOptions{}, received},
+		{&TimeseriesOptions{EventTime: true}, time.Date(2018, 3, 13, 19, 46, 3, 0, time.UTC)},
+		{berlin, time.Date(2018, 3, 13, 18, 46, 3, 0, time.UTC)},
+	} {
+		actual := (&SensorEvent{Event: e, options: c.options}).Timestamp(received)
+		if !actual.Equal(c.expected) {
+			t.Errorf("%+v: expected %s, got %s", c.options, c.expected, actual)
+		}
+	}
+
+	invalid := &TimeseriesOptions{LastUpdatedTimezone: "Mars/Olympus_Mons"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected an unknown timezone to be rejected")
+	}
+}
+
+func TestParseLastUpdated(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	utc := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		value    string
+		location *time.Location
+		expected time.Time
+	}{
+		{"2021-01-01T12:00:00", time.UTC, utc},
+		{"2021-01-01T13:00:00", berlin, utc},
+		{"2021-01-01T12:00:00.123", time.UTC, utc.Add(123 * time.Millisecond)},
+		{"2021-01-01T12:00:00Z", berlin, utc},
+		{"2021-01-01T12:00:00.5Z", berlin, utc.Add(500 * time.Millisecond)},
+		{"2021-01-01T14:00:00+02:00", time.UTC, utc},
+		{"2021-01-01 12:00:00", time.UTC, utc},
+	} {
+		actual, ok := parseLastUpdated(c.value, c.location)
+		if !ok || !actual.Equal(c.expected) {
+			t.Errorf("%s: expected %s, got %s (%t)", c.value, c.expected, actual, ok)
+		}
+	}
+
+	for _, value := range []string{"none", "", "yesterday"} {
+		if _, ok := parseLastUpdated(value, time.UTC); ok {
+			t.Errorf("expected %q to fall back to the time received", value)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package deconz
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ssdpAddr is the multicast address UPnP devices listen for searches on
+const ssdpAddr = "239.255.255.250:1900"
+
+// DefaultUPnPTimeout is how long to wait for gateways to answer a UPnP search
+const DefaultUPnPTimeout = 3 * time.Second
+
+// deconzBridgeIDPrefix is the OUI of dresden elektronik, all deCONZ bridge ids start with it
+const deconzBridgeIDPrefix = "00212E"
+
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: " + ssdpAddr + "\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: urn:schemas-upnp-org:device:basic:1\r\n\r\n"
+
+// discoverUPnP sends a UPnP search on the local network and collects the deCONZ gateways answering within timeout
+func discoverUPnP(timeout time.Duration) ([]Gateway, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for upnp responses: %s", err)
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.WriteTo([]byte(ssdpSearch), dst)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send upnp search: %s", err)
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	var gateways []Gateway
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// the deadline has passed, no more responses
+			break
+		}
+
+		if gateway, ok := parseSSDPResponse(buf[:n]); ok {
+			gateways = append(gateways, gateway)
+		}
+	}
+
+	if len(gateways) == 0 {
+		return nil, fmt.Errorf("no gateways answered the upnp search")
+	}
+
+	return gateways, nil
+}
+
+// parseSSDPResponse parses a search response, ok is false if it was not sent by a deCONZ gateway
+func parseSSDPResponse(b []byte) (Gateway, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil {
+		return Gateway{}, false
+	}
+	resp.Body.Close()
+
+	id := strings.ToUpper(resp.Header.Get("hue-bridgeid"))
+	if !strings.HasPrefix(id, deconzBridgeIDPrefix) {
+		return Gateway{}, false
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Hostname() == "" {
+		return Gateway{}, false
+	}
+
+	port := uint(80)
+	if p, err := strconv.ParseUint(location.Port(), 10, 16); err == nil {
+		port = uint(p)
+	}
+
+	return Gateway{
+		ID:                id,
+		InternalIPAddress: location.Hostname(),
+		InternalPort:      port,
+	}, true
+}
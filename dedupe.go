@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var dedupedEvents = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "deflux_deduplicated_events_total",
+	Help: "Number of events not written as they repeated the last written fields of their sensor.",
+})
+
+// deduper detects events repeating the fields last written for a sensor
+type deduper struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	last map[string]writtenFields
+}
+
+type writtenFields struct {
+	fields string
+	at     time.Time
+}
+
+// newDeduper returns a deduper suppressing repeated fields within window, or nil if window is zero
+func newDeduper(window time.Duration) *deduper {
+	if window <= 0 {
+		return nil
+	}
+	return &deduper{window: window, last: make(map[string]writtenFields)}
+}
+
+// duplicate reports if fields are identical to the fields last written for sensor within the window,
+// if not the fields are remembered as the last written
+func (d *deduper) duplicate(sensor string, fields map[string]interface{}, now time.Time) bool {
+	f := fingerprint(fields)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if last, found := d.last[sensor]; found && last.fields == f && now.Sub(last.at) < d.window {
+		return true
+	}
+
+	d.last[sensor] = writtenFields{fields: f, at: now}
+	return false
+}
+
+// fingerprint serializes fields including their types, sorted by key
+func fingerprint(fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		// the sequence field differs for every event
+		if k == "sequence" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%s=%T(%v),", k, fields[k], fields[k])
+	}
+	return sb.String()
+}
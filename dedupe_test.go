@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	d := newDeduper(time.Minute)
+	now := time.Now()
+
+	if d.duplicate("5", map[string]interface{}{"fire": false}, now) {
+		t.Error("first event can not be a duplicate")
+	}
+	if !d.duplicate("5", map[string]interface{}{"fire": false}, now.Add(time.Second)) {
+		t.Error("expected identical event within the window to be a duplicate")
+	}
+	if d.duplicate("6", map[string]interface{}{"fire": false}, now.Add(time.Second)) {
+		t.Error("events from other sensors are not duplicates")
+	}
+	if d.duplicate("5", map[string]interface{}{"fire": true}, now.Add(2*time.Second)) {
+		t.Error("changed fields are not duplicates")
+	}
+	if d.duplicate("5", map[string]interface{}{"fire": true}, now.Add(2*time.Minute)) {
+		t.Error("identical fields outside the window are not duplicates")
+	}
+	if d.duplicate("7", map[string]interface{}{"value": 1}, now) || d.duplicate("7", map[string]interface{}{"value": 1.0}, now) {
+		t.Error("fields with different types are not duplicates")
+	}
+	if d.duplicate("8", map[string]interface{}{"fire": false, "sequence": int64(1)}, now) || !d.duplicate("8", map[string]interface{}{"fire": false, "sequence": int64(2)}, now) {
+		t.Error("expected events differing only by sequence to be duplicates")
+	}
+}
+
+func TestNewDeduperDisabled(t *testing.T) {
+	if newDeduper(0) != nil {
+		t.Error("expected no deduper without a window")
+	}
+}
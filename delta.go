@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// deltaConfig derives delta fields from monotonic counters such as the
+// consumption of energy meters
+type deltaConfig struct {
+	// Fields lists the counters <field>_delta is written for, e.g. consumption
+	Fields []string
+	// OnReset is the delta written when a counter decreases, zero (default)
+	// or raw for the value the counter was reset to
+	OnReset string
+}
+
+// deltas remembers the last value of every counter per sensor
+type deltas struct {
+	fields map[string]bool
+	raw    bool
+
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+// newDeltas returns deltas for the configured counters, or nil if none are configured
+func newDeltas(c deltaConfig) (*deltas, error) {
+	if len(c.Fields) == 0 {
+		return nil, nil
+	}
+
+	var raw bool
+	switch c.OnReset {
+	case "", "zero":
+	case "raw":
+		raw = true
+	default:
+		return nil, fmt.Errorf("onreset must be zero or raw, got %s", c.OnReset)
+	}
+
+	d := &deltas{fields: make(map[string]bool, len(c.Fields)), raw: raw, last: make(map[string]float64)}
+	for _, field := range c.Fields {
+		d.fields[field] = true
+	}
+	return d, nil
+}
+
+// add adds the delta of every counter in fields since the last event of
+// sensor, there is no delta for the first value of a counter
+func (d *deltas) add(sensor string, fields map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for field := range d.fields {
+		value, integer, ok := numericValue(fields[field])
+		if !ok {
+			continue
+		}
+
+		key := sensor + "\x00" + field
+		last, found := d.last[key]
+		d.last[key] = value
+		if !found {
+			continue
+		}
+
+		delta := value - last
+		if delta < 0 {
+			delta = 0
+			if d.raw {
+				delta = value
+			}
+		}
+		if integer {
+			fields[field+"_delta"] = int64(delta)
+		} else {
+			fields[field+"_delta"] = delta
+		}
+	}
+}
+
+// numericValue returns v as float64 and if it is an integer, ok is false if v is not a number
+func numericValue(v interface{}) (value float64, integer bool, ok bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true, true
+	case int64:
+		return float64(v), true, true
+	case float64:
+		return v, false, true
+	}
+	return 0, false, false
+}
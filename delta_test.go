@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestDeltas(t *testing.T) {
+	for _, c := range []struct {
+		onReset  string
+		values   []interface{}
+		expected []interface{}
+	}{
+		{"", []interface{}{int64(100), int64(150), int64(150), int64(20), int64(30)}, []interface{}{nil, int64(50), int64(0), int64(0), int64(10)}},
+		{"raw", []interface{}{int64(100), int64(20)}, []interface{}{nil, int64(20)}},
+		{"zero", []interface{}{1.5, 2.25}, []interface{}{nil, 0.75}},
+	} {
+		d, err := newDeltas(deltaConfig{Fields: []string{"consumption"}, OnReset: c.onReset})
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+
+		for i, value := range c.values {
+			fields := map[string]interface{}{"consumption": value, "power": 42}
+			d.add("1", fields)
+			if delta := fields["consumption_delta"]; delta != c.expected[i] {
+				t.Errorf("onreset %q: expected delta %v after %v, got %v", c.onReset, c.expected[i], c.values[:i+1], delta)
+			}
+			if _, found := fields["power_delta"]; found {
+				t.Error("expected no delta of fields not configured")
+			}
+		}
+	}
+}
+
+func TestDeltasPerSensor(t *testing.T) {
+	d, _ := newDeltas(deltaConfig{Fields: []string{"consumption"}})
+	d.add("1", map[string]interface{}{"consumption": int64(100)})
+
+	fields := map[string]interface{}{"consumption": int64(500)}
+	d.add("2", fields)
+	if _, found := fields["consumption_delta"]; found {
+		t.Error("expected no delta for the first value of another sensor")
+	}
+}
+
+func TestNewDeltas(t *testing.T) {
+	if d, err := newDeltas(deltaConfig{}); d != nil || err != nil {
+		t.Error("expected no deltas without fields")
+	}
+	if _, err := newDeltas(deltaConfig{Fields: []string{"consumption"}, OnReset: "ignore"}); err == nil {
+		t.Error("expected an error on an unknown onreset")
+	}
+}
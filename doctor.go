@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// doctorTimeout bounds every check of deflux doctor talking to influxdb
+const doctorTimeout = 10 * time.Second
+
+// doctorCheck is a single check of deflux doctor, run returns a hint how to
+// fix the problem along with the error
+type doctorCheck struct {
+	name string
+	run  func() (hint string, err error)
+}
+
+// errSkipped is returned by checks which do not apply to the configuration
+var errSkipped = errors.New("skipped")
+
+// doctorCommand checks the configuration, the gateway and influxdb and prints
+// a checklist of the results
+func doctorCommand(args []string) error {
+	config, err := loadFlagConfiguration()
+	if err != nil {
+		runDoctor(os.Stdout, []doctorCheck{{"configuration", func() (string, error) {
+			return "run deflux gen-config to generate a configuration", err
+		}}})
+		return errors.New("no configuration could be loaded")
+	}
+
+	failed := runDoctor(os.Stdout, doctorChecks(config))
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// runDoctor runs checks in order and writes their results to w, returning how many failed
+func runDoctor(w io.Writer, checks []doctorCheck) int {
+	failed := 0
+	for _, c := range checks {
+		hint, err := c.run()
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "[ok]   %s\n", c.name)
+		case err == errSkipped:
+			fmt.Fprintf(w, "[skip] %s: %s\n", c.name, hint)
+		default:
+			failed++
+			fmt.Fprintf(w, "[fail] %s: %s\n", c.name, err)
+			if hint != "" {
+				fmt.Fprintf(w, "       %s\n", hint)
+			}
+		}
+	}
+	return failed
+}
+
+// doctorChecks returns the checks of config
+func doctorChecks(config *Configuration) []doctorCheck {
+	return []doctorCheck{
+		{"configuration", func() (string, error) {
+			return "fix the configuration, see the README for every option", validateConfiguration(config)
+		}},
+		{"discovery", func() (string, error) {
+			if *noDiscoverFlag {
+				return "--no-discover is set", errSkipped
+			}
+			if config.Discovery.Mode == deconz.DiscoveryOff {
+				return "discovery mode is off", errSkipped
+			}
+			_, err := deconz.DiscoverWith(config.Discovery)
+			return "discovery is only needed by gen-config, give the address of the gateway as addr", err
+		}},
+		{"gateway rest api", func() (string, error) {
+			err := (&deconz.API{Config: config.Deconz}).Check()
+			if err == deconz.ErrUnauthorized {
+				return "unlock the gateway in the deCONZ app and run deflux gen-config to pair again", err
+			}
+			return fmt.Sprintf("check that deCONZ runs and addr %s points to its rest api", config.Deconz.Addr), err
+		}},
+		{"gateway websocket", func() (string, error) {
+			if config.Deconz.PollInterval > 0 {
+				return "polling is configured", errSkipped
+			}
+			return "check that the websocket port of deCONZ is reachable from this host", dialWebsocket(config.Deconz)
+		}},
+		{"influxdb", func() (string, error) {
+			if !config.Influxdb2.enabled() {
+				return "influxdb2 is disabled", errSkipped
+			}
+			return fmt.Sprintf("check that influxdb runs at url %s", config.Influxdb2.URL), checkInflux(config.Influxdb2)
+		}},
+		{"influxdb bucket", func() (string, error) {
+			if !config.Influxdb2.enabled() {
+				return "influxdb2 is disabled", errSkipped
+			}
+			return "create the bucket, or give the token read access to it, influx compatible endpoints have no buckets to check", checkBucket(config.Influxdb2)
+		}},
+	}
+}
+
+// dialWebsocket connects to the websocket of the gateway once
+func dialWebsocket(c deconz.Config) error {
+	c.Reconnect = deconz.ReconnectOptions{Attempts: 1}
+	reader, err := (&deconz.API{Config: c}).EventReader()
+	if err != nil {
+		return err
+	}
+	err = reader.Dial()
+	if err != nil {
+		return err
+	}
+	return reader.Close()
+}
+
+// checkInflux runs the health check of influxdb
+func checkInflux(c influxdb2ConfigProxy) error {
+	client := influxdb2.NewClientWithOptions(c.URL, c.Token, c.options(nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	health, err := client.Health(ctx)
+	if err != nil {
+		return err
+	}
+	if health.Status != "pass" {
+		return fmt.Errorf("influxdb reports %s", health.Status)
+	}
+	return nil
+}
+
+// checkBucket looks up the bucket points are written to
+func checkBucket(c influxdb2ConfigProxy) error {
+	client := influxdb2.NewClientWithOptions(c.URL, c.Token, c.options(nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	_, err := client.BucketsAPI().FindBucketByName(ctx, c.Bucket)
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/internal/deconztest"
+)
+
+func TestRunDoctor(t *testing.T) {
+	var b bytes.Buffer
+	failed := runDoctor(&b, []doctorCheck{
+		{"passing", func() (string, error) { return "never shown", nil }},
+		{"failing", func() (string, error) { return "try turning it off and on again", errors.New("broken") }},
+		{"skipped", func() (string, error) { return "not configured", errSkipped }},
+	})
+
+	expected := "[ok]   passing\n[fail] failing: broken\n       try turning it off and on again\n[skip] skipped: not configured\n"
+	if failed != 1 || b.String() != expected {
+		t.Errorf("expected 1 failure and %q, got %d and %q", expected, failed, b.String())
+	}
+}
+
+func TestDoctorChecks(t *testing.T) {
+	*noDiscoverFlag = true
+	defer func() { *noDiscoverFlag = false }()
+
+	gateway := deconztest.NewGateway("secret", gatewaySensors)
+	defer gateway.Close()
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"name":"influxdb","message":"ready for queries and writes","status":"pass","checks":[]}`))
+		case "/api/v2/buckets":
+			w.Write([]byte(`{"buckets":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer influx.Close()
+
+	config := &Configuration{Deconz: deconz.Config{Addr: gateway.Addr(), APIKey: "secret"}}
+	config.Influxdb2 = influxdb2ConfigProxy{URL: influx.URL, Bucket: "deconz", BatchSize: 20}
+
+	var b bytes.Buffer
+	failed := runDoctor(&b, doctorChecks(config))
+	for _, expected := range []string{
+		"[ok]   configuration\n",
+		"[skip] discovery: --no-discover is set\n",
+		"[ok]   gateway rest api\n",
+		"[ok]   gateway websocket\n",
+		"[ok]   influxdb\n",
+		"[fail] influxdb bucket: ",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected %q in %s", expected, b.String())
+		}
+	}
+	if failed != 1 {
+		t.Errorf("expected only the missing bucket to fail, got %d failures", failed)
+	}
+}
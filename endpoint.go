@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// endpointTransport adapts the write requests of the influxdb client to
+// influx compatible endpoints such as VictoriaMetrics
+type endpointTransport struct {
+	http.RoundTripper
+	// writePath replaces the path of write requests if set
+	writePath string
+	// authScheme replaces the Token scheme of the Authorization header if set,
+	// Basic encodes the token as user:password
+	authScheme string
+	// headers are sent with every request, e.g. for an authenticating proxy
+	headers map[string]string
+}
+
+// validateHeaders returns an error if a header name is not a valid http token, a
+// value holds a line break or a header is set by the influxdb client itself
+func validateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isTokenRune(r) }) >= 0 {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("value of header %s must not contain line breaks", name)
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Content-Type", "Content-Length", "Content-Encoding", "Host":
+			return fmt.Errorf("header %s is set by deflux, use token and authscheme for credentials", name)
+		}
+	}
+	return nil
+}
+
+// isTokenRune reports if r may be part of a header name
+func isTokenRune(r rune) bool {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	isWrite := strings.HasSuffix(req.URL.Path, "/write")
+	auth := req.Header.Get("Authorization")
+	rewriteAuth := t.authScheme != "" && strings.HasPrefix(auth, "Token ")
+	if !rewriteAuth && (t.writePath == "" || !isWrite) && len(t.headers) == 0 {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	// round trippers must not modify the request they are given
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	if t.writePath != "" && isWrite {
+		req.URL.Path = t.writePath
+		req.URL.RawPath = ""
+	}
+
+	if rewriteAuth {
+		token := strings.TrimPrefix(auth, "Token ")
+		switch {
+		case token == "":
+			req.Header.Del("Authorization")
+		case strings.EqualFold(t.authScheme, "basic"):
+			req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+		default:
+			req.Header.Set("Authorization", t.authScheme+" "+token)
+		}
+	}
+
+	return t.RoundTripper.RoundTrip(req)
+}
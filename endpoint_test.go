@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+func TestEndpointTransport(t *testing.T) {
+	var path, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		transport endpointTransport
+		token     string
+		path      string
+		auth      string
+	}{
+		{endpointTransport{}, "secret", "/api/v2/write", "Token secret"},
+		{endpointTransport{writePath: "/influx/write"}, "secret", "/influx/write", "Token secret"},
+		{endpointTransport{authScheme: "Bearer"}, "secret", "/api/v2/write", "Bearer secret"},
+		{endpointTransport{authScheme: "Basic"}, "user:password", "/api/v2/write", "Basic dXNlcjpwYXNzd29yZA=="},
+		{endpointTransport{authScheme: "Bearer"}, "", "/api/v2/write", ""},
+	} {
+		transport := c.transport
+		transport.RoundTripper = http.DefaultTransport
+		client := http.Client{Transport: &transport}
+
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v2/write?bucket=deconz", strings.NewReader("m v=1\n"))
+		req.Header.Set("Authorization", "Token "+c.token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		resp.Body.Close()
+
+		if path != c.path || auth != c.auth {
+			t.Errorf("expected %s with %q, got %s with %q", c.path, c.auth, path, auth)
+		}
+		if req.Header.Get("Authorization") != "Token "+c.token {
+			t.Error("the original request was modified")
+		}
+	}
+}
+
+func TestEndpointTransportHeaders(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := http.Client{Transport: &endpointTransport{RoundTripper: http.DefaultTransport, headers: map[string]string{"X-Proxy-Auth": "letmein"}}}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v2/write?bucket=deconz", strings.NewReader("m v=1\n"))
+	req.Header.Set("Authorization", "Token secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	if header.Get("X-Proxy-Auth") != "letmein" || header.Get("Authorization") != "Token secret" {
+		t.Errorf("expected the configured header along with the token, got %v", header)
+	}
+	if req.Header.Get("X-Proxy-Auth") != "" {
+		t.Error("the original request was modified")
+	}
+
+	for headers, valid := range map[string]bool{
+		"X-Proxy-Auth: letmein":   true,
+		"X Proxy: letmein":        false,
+		"X-Proxy: let\r\nme: in":  false,
+		"authorization: Bearer x": false,
+		": empty":                 false,
+	} {
+		parts := strings.SplitN(headers, ": ", 2)
+		if err := validateHeaders(map[string]string{parts[0]: parts[1]}); (err == nil) != valid {
+			t.Errorf("%q: expected valid to be %t, got %v", headers, valid, err)
+		}
+	}
+}
+
+func TestEndpointTransportTraceRedactsHeaders(t *testing.T) {
+	defer logging.SetLevel(logging.GetLevel())
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	headers := map[string]string{"X-Api-Key": "proxy-s3cr3t"}
+	redactSecrets(&Configuration{Influxdb2: influxdb2ConfigProxy{Headers: headers}})
+	logging.SetLevel(logging.TraceLevel)
+
+	client := http.Client{Transport: &endpointTransport{RoundTripper: &logging.TraceTransport{RoundTripper: http.DefaultTransport}, headers: headers}}
+	resp, err := client.Post(server.URL+"/api/v2/write", "text/plain", strings.NewReader("m v=1\n"))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	traced := b.String()
+	if !strings.Contains(traced, "X-Api-Key: [redacted]") || strings.Contains(traced, "proxy-s3cr3t") {
+		t.Errorf("expected the value of X-Api-Key to be redacted in trace %s", traced)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/logging"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// exportSensorsCommand writes every sensor known to the gateway as json or
+// yaml, to stdout or --output
+func exportSensorsCommand(args []string) error {
+	flags := flag.NewFlagSet("export-sensors", flag.ContinueOnError)
+	format := flags.String("format", "json", "format of the export, json or yaml")
+	output := flags.String("output", "", "file to write the sensors to instead of stdout")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadFlagConfiguration()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err = exportSensors(w, &deconz.API{Config: config.Deconz}, *format)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		logging.Infof("Wrote sensors to %s", *output)
+	}
+	return nil
+}
+
+// exportSensors writes the sensors of api to w in format, sensors and their
+// fields are sorted so exports can be diffed
+func exportSensors(w io.Writer, api *deconz.API, format string) error {
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("unknown format %q, use json or yaml", format)
+	}
+
+	sensors, err := api.RawSensors()
+	if err != nil {
+		return fmt.Errorf("unable to get sensors: %s", err)
+	}
+
+	if format == "yaml" {
+		e := yaml.NewEncoder(w)
+		err = e.Encode(sensors)
+		if err != nil {
+			return err
+		}
+		return e.Close()
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(sensors)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/internal/deconztest"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestExportSensors(t *testing.T) {
+	gateway := deconztest.NewGateway("secret", gatewaySensors)
+	defer gateway.Close()
+	api := &deconz.API{Config: deconz.Config{Addr: gateway.Addr(), APIKey: "secret"}}
+
+	var b bytes.Buffer
+	err := exportSensors(&b, api, "json")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	var exported map[string]map[string]interface{}
+	err = json.Unmarshal(b.Bytes(), &exported)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	state, _ := exported["5"]["state"].(map[string]interface{})
+	if exported["5"]["name"] != "Kitchen" || state["temperature"] != 2150.0 {
+		t.Errorf("unexpected json export %s", b.String())
+	}
+
+	b.Reset()
+	err = exportSensors(&b, api, "yaml")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	var exportedYAML map[int]map[string]interface{}
+	err = yaml.Unmarshal(b.Bytes(), &exportedYAML)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	stateYAML, _ := exportedYAML[5]["state"].(map[interface{}]interface{})
+	if exportedYAML[5]["name"] != "Kitchen" || stateYAML["temperature"] != 2150 {
+		t.Errorf("unexpected yaml export %s", b.String())
+	}
+
+	if err := exportSensors(&b, api, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// fieldMapping renames fields to a common schema across sensor types, keys are
+// a field name or a sensor type and field name joined by a dot, e.g.
+// "ZHAOpenClose.open", which takes precedence over the plain field name
+type fieldMapping map[string]string
+
+// loadFieldMapping reads a yaml mapping of field names to their new names
+func loadFieldMapping(name string) (fieldMapping, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read field mapping: %s", err)
+	}
+
+	var m fieldMapping
+	err = yaml.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse field mapping: %s", err)
+	}
+	return m, nil
+}
+
+// rename returns fields with their mapped names, unmapped fields pass through
+// unchanged and fields is returned as is if none of them are mapped
+func (m fieldMapping) rename(sensorType string, fields map[string]interface{}) map[string]interface{} {
+	if len(m) == 0 {
+		return fields
+	}
+
+	var mapped map[string]string
+	for k := range fields {
+		to, ok := m[sensorType+"."+k]
+		if !ok {
+			to, ok = m[k]
+		}
+		if !ok || to == k {
+			continue
+		}
+		if mapped == nil {
+			mapped = make(map[string]string)
+		}
+		mapped[k] = to
+	}
+
+	if mapped == nil {
+		return fields
+	}
+
+	// renamed fields overwrite unmapped fields with the same name
+	result := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if _, ok := mapped[k]; !ok {
+			result[k] = v
+		}
+	}
+	for k, to := range mapped {
+		result[to] = fields[k]
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFieldMappingRename(t *testing.T) {
+	m := fieldMapping{
+		"airqualityppb":     "voc",
+		"ZHAOpenClose.open": "contact",
+		"open":              "opened",
+	}
+
+	fields := map[string]interface{}{"temperature": 21.5}
+	if renamed := m.rename("ZHATemperature", fields); !reflect.DeepEqual(renamed, fields) {
+		t.Errorf("expected unmapped fields to pass through, got %v", renamed)
+	}
+
+	renamed := m.rename("ZHAAirQuality", map[string]interface{}{"airquality": "good", "airqualityppb": 120})
+	if !reflect.DeepEqual(renamed, map[string]interface{}{"airquality": "good", "voc": 120}) {
+		t.Errorf("unexpected renamed fields %v", renamed)
+	}
+
+	// the sensor type specific mapping wins
+	renamed = m.rename("ZHAOpenClose", map[string]interface{}{"open": true})
+	if !reflect.DeepEqual(renamed, map[string]interface{}{"contact": true}) {
+		t.Errorf("unexpected renamed fields %v", renamed)
+	}
+	renamed = m.rename("Other", map[string]interface{}{"open": true})
+	if !reflect.DeepEqual(renamed, map[string]interface{}{"opened": true}) {
+		t.Errorf("unexpected renamed fields %v", renamed)
+	}
+}
+
+func TestLoadFieldMapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deflux")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "fields.yml")
+	err = ioutil.WriteFile(name, []byte("airqualityppb: voc\nZHAOpenClose.open: contact\n"), 0600)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	m, err := loadFieldMapping(name)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if m["airqualityppb"] != "voc" || m["ZHAOpenClose.open"] != "contact" {
+		t.Errorf("unexpected mapping %v", m)
+	}
+}
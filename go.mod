@@ -3,7 +3,11 @@ module github.com/dfuchslin/deflux
 go 1.16
 
 require (
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/websocket v1.4.2
 	github.com/influxdata/influxdb-client-go/v2 v2.2.2
+	github.com/prometheus/client_golang v1.11.1
+	github.com/zalando/go-keyring v0.1.1
+	google.golang.org/protobuf v1.26.0-rc.1
 	gopkg.in/yaml.v2 v2.4.0
 )
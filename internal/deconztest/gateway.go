@@ -0,0 +1,183 @@
+// Package deconztest provides a fake deCONZ gateway for tests
+package deconztest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Gateway emulates the rest api and websocket of a deCONZ gateway, it
+// answers pairing, config and sensors requests and streams events to
+// websocket clients
+type Gateway struct {
+	// APIKey is handed out when pairing and required by the rest api
+	APIKey string
+	// Sensors is the json returned by the sensors endpoint
+	Sensors string
+	// Locked makes pairing fail as if the link button was not pressed
+	Locked bool
+	// SWVersion is the firmware version returned by the config endpoint
+	SWVersion string
+	// BridgeID is the bridge id returned by the config endpoint
+	BridgeID string
+
+	api       *httptest.Server
+	websocket *httptest.Server
+	upgrader  websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*websocket.Conn]bool
+	joined  *sync.Cond
+}
+
+// NewGateway starts a gateway handing out apikey and serving sensors,
+// Close it when done
+func NewGateway(apikey, sensors string) *Gateway {
+	g := &Gateway{
+		APIKey:  apikey,
+		Sensors: sensors,
+		clients: make(map[*websocket.Conn]bool),
+	}
+	g.joined = sync.NewCond(&g.mu)
+	g.api = httptest.NewServer(http.HandlerFunc(g.serveAPI))
+	g.websocket = httptest.NewServer(http.HandlerFunc(g.serveWebsocket))
+	return g
+}
+
+// Addr returns the address of the rest api as used in the deconz configuration
+func (g *Gateway) Addr() string {
+	return g.api.URL + "/api"
+}
+
+// Send sends event to every connected websocket client, it waits for a
+// client to connect if there are none
+func (g *Gateway) Send(event string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(g.clients) == 0 {
+		g.joined.Wait()
+	}
+
+	for conn := range g.clients {
+		conn.WriteMessage(websocket.TextMessage, []byte(event))
+	}
+}
+
+// Disconnect closes the connection of every websocket client
+func (g *Gateway) Disconnect() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for conn := range g.clients {
+		conn.Close()
+		delete(g.clients, conn)
+	}
+}
+
+// Close disconnects every client and stops the gateway
+func (g *Gateway) Close() {
+	g.Disconnect()
+	g.websocket.Close()
+	g.api.Close()
+}
+
+func (g *Gateway) serveAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	if path == "api" && r.Method == http.MethodPost {
+		g.pair(w)
+		return
+	}
+
+	// the api key is either part of the path or sent in a header
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] != "api" {
+		http.NotFound(w, r)
+		return
+	}
+	if parts[1] == g.APIKey {
+		parts = parts[2:]
+	} else if hasHeaderKey(r.Header, g.APIKey) {
+		parts = parts[1:]
+	} else {
+		writeError(w, http.StatusForbidden, 1, "unauthorized user")
+		return
+	}
+
+	switch strings.Join(parts, "/") {
+	case "config":
+		u, _ := url.Parse(g.websocket.URL)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"websocketport":%s,"swversion":%q,"bridgeid":%q}`, u.Port(), g.SWVersion, g.BridgeID)
+	case "sensors":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(g.Sensors))
+	default:
+		writeError(w, http.StatusNotFound, 3, "resource not available")
+	}
+}
+
+func (g *Gateway) pair(w http.ResponseWriter) {
+	if g.Locked {
+		writeError(w, http.StatusForbidden, 101, "link button not pressed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]interface{}{
+		map[string]interface{}{"success": map[string]string{"username": g.APIKey}},
+	})
+}
+
+func (g *Gateway) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	g.mu.Lock()
+	g.clients[conn] = true
+	g.joined.Broadcast()
+	g.mu.Unlock()
+
+	// clients never send anything, read until they hang up
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+
+	g.mu.Lock()
+	delete(g.clients, conn)
+	g.mu.Unlock()
+	conn.Close()
+}
+
+// hasHeaderKey reports if any header carries the api key
+func hasHeaderKey(h http.Header, key string) bool {
+	for _, values := range h {
+		for _, v := range values {
+			if v == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status, errorType int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode([]interface{}{
+		map[string]interface{}{"error": map[string]interface{}{"type": errorType, "description": description}},
+	})
+}
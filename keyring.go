@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/dfuchslin/deflux/logging"
+	"github.com/zalando/go-keyring"
+)
+
+// defaultKeyringService is the keyring service secrets are looked up in unless configured
+const defaultKeyringService = "deflux"
+
+// keyring users of the secrets looked up
+const (
+	keyringAPIKeyUser = "deconz-apikey"
+	keyringTokenUser  = "influxdb2-token"
+)
+
+// keyringConfig reads the deconz api key and the influxdb2 token from the
+// keyring of the OS, the secrets in the configuration are used if missing
+type keyringConfig struct {
+	Enabled bool
+	// Service defaults to defaultKeyringService
+	Service string
+}
+
+// applyKeyring replaces the secrets of config with the ones in the keyring
+func applyKeyring(config *Configuration) {
+	if !config.Keyring.Enabled {
+		return
+	}
+	service := config.Keyring.Service
+	if service == "" {
+		service = defaultKeyringService
+	}
+
+	for user, secret := range map[string]*string{
+		keyringAPIKeyUser: &config.Deconz.APIKey,
+		keyringTokenUser:  &config.Influxdb2.Token,
+	} {
+		value, err := keyring.Get(service, user)
+		if errors.Is(err, keyring.ErrNotFound) {
+			logging.Debugf("%s is not in the keyring service %s, using the configuration", user, service)
+			continue
+		}
+		if err != nil {
+			logging.Warnf("unable to read %s from the keyring, using the configuration: %s", user, err)
+			continue
+		}
+		*secret = value
+	}
+}
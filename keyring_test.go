@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestApplyKeyring(t *testing.T) {
+	keyring.MockInit()
+	err := keyring.Set("deflux-test", keyringAPIKeyUser, "from keyring")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	config := &Configuration{Keyring: keyringConfig{Enabled: true, Service: "deflux-test"}}
+	config.Deconz.APIKey = "from yaml"
+	config.Influxdb2.Token = "token from yaml"
+	applyKeyring(config)
+
+	if config.Deconz.APIKey != "from keyring" {
+		t.Errorf("expected the api key from the keyring, got %s", config.Deconz.APIKey)
+	}
+	if config.Influxdb2.Token != "token from yaml" {
+		t.Errorf("expected the token missing in the keyring to be kept, got %s", config.Influxdb2.Token)
+	}
+
+	config.Keyring.Enabled = false
+	config.Deconz.APIKey = "from yaml"
+	applyKeyring(config)
+	if config.Deconz.APIKey != "from yaml" {
+		t.Errorf("expected the keyring to be ignored while disabled, got %s", config.Deconz.APIKey)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var oversizedPoints = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "deflux_oversized_points_dropped_total",
+	Help: "Number of points dropped as they exceeded maxfields, maxtags or maxpointsize, by the limit exceeded.",
+}, []string{"limit"})
+
+// pointLimits guards influxdb against sensors emitting excessive fields or
+// tags, zero disables a limit
+type pointLimits struct {
+	MaxFields int
+	MaxTags   int
+	// MaxPointSize is the most bytes of line protocol a point may take
+	MaxPointSize int
+}
+
+// oversizedSensors remembers the sensors already warned about
+var oversizedSensors sync.Map
+
+// exceeded reports if a point of sensor with tags and fields exceeds the
+// limits, it is counted and a warning is logged the first time per sensor
+func (l pointLimits) exceeded(sensor string, tags map[string]string, fields map[string]interface{}) bool {
+	err := l.check(tags, fields)
+	if err == nil {
+		return false
+	}
+	drop(sensor, err)
+	return true
+}
+
+// oversized reports if the line protocol of p from sensor exceeds
+// MaxPointSize, it is counted and warned about like exceeded
+func (l pointLimits) oversized(sensor string, p *write.Point) bool {
+	if l.MaxPointSize <= 0 {
+		return false
+	}
+	// nanoseconds are the longest timestamps of every precision
+	size := len(write.PointToLineProtocol(p, time.Nanosecond))
+	if size <= l.MaxPointSize {
+		return false
+	}
+	drop(sensor, &limitError{limit: "bytes", count: size, max: l.MaxPointSize})
+	return true
+}
+
+// drop counts a point of sensor dropped for err, warning the first time per sensor
+func drop(sensor string, err *limitError) {
+	oversizedPoints.WithLabelValues(err.limit).Inc()
+	if _, warned := oversizedSensors.LoadOrStore(sensor, true); !warned {
+		logging.Warnf("dropping points of sensor %s: %s", sensor, err)
+	}
+}
+
+type limitError struct {
+	limit      string
+	count, max int
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%d %s exceed the limit of %d", e.count, e.limit, e.max)
+}
+
+// check returns the limit exceeded by tags and fields, if any
+func (l pointLimits) check(tags map[string]string, fields map[string]interface{}) *limitError {
+	if l.MaxFields > 0 && len(fields) > l.MaxFields {
+		return &limitError{limit: "fields", count: len(fields), max: l.MaxFields}
+	}
+	if l.MaxTags > 0 && len(tags) > l.MaxTags {
+		return &limitError{limit: "tags", count: len(tags), max: l.MaxTags}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPointLimits(t *testing.T) {
+	tags := map[string]string{"id": "1", "name": "Kitchen", "type": "ZHATemperature"}
+	fields := map[string]interface{}{"temperature": 21.5, "battery": 100}
+
+	for _, c := range []struct {
+		limits   pointLimits
+		exceeded string
+	}{
+		{pointLimits{}, ""},
+		{pointLimits{MaxFields: 2, MaxTags: 3}, ""},
+		{pointLimits{MaxFields: 1}, "fields"},
+		{pointLimits{MaxTags: 2}, "tags"},
+	} {
+		err := c.limits.check(tags, fields)
+		if c.exceeded == "" && err != nil {
+			t.Errorf("unexpected error with limits %+v: %s", c.limits, err)
+		}
+		if c.exceeded != "" && (err == nil || err.limit != c.exceeded) {
+			t.Errorf("expected %s to exceed limits %+v, got %v", c.exceeded, c.limits, err)
+		}
+	}
+
+	before := testutil.ToFloat64(oversizedPoints.WithLabelValues("fields"))
+	if !(pointLimits{MaxFields: 1}).exceeded("1", tags, fields) {
+		t.Error("expected the point to exceed the limits")
+	}
+	if dropped := testutil.ToFloat64(oversizedPoints.WithLabelValues("fields")) - before; dropped != 1 {
+		t.Errorf("expected one dropped point to be counted, got %f", dropped)
+	}
+}
+
+func TestPointLimitsSize(t *testing.T) {
+	tags := map[string]string{"id": "1", "name": "Kitchen", "type": "ZHATemperature"}
+	p := influxdb2.NewPoint("deflux_ZHATemperature", tags, map[string]interface{}{"temperature": 21.5}, time.Unix(0, 0))
+	// deflux_ZHATemperature,id=1,name=Kitchen,type=ZHATemperature temperature=21.5 0\n
+	size := 79
+
+	if (pointLimits{}).oversized("1", p) || (pointLimits{MaxPointSize: size}).oversized("1", p) {
+		t.Error("expected the point to fit")
+	}
+
+	before := testutil.ToFloat64(oversizedPoints.WithLabelValues("bytes"))
+	if !(pointLimits{MaxPointSize: size - 1}).oversized("1", p) {
+		t.Error("expected the point to be oversized")
+	}
+	if dropped := testutil.ToFloat64(oversizedPoints.WithLabelValues("bytes")) - before; dropped != 1 {
+		t.Errorf("expected one dropped point to be counted, got %f", dropped)
+	}
+}
@@ -0,0 +1,127 @@
+// Package logging provides leveled logging on top of the standard logger
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level is a log level, messages below the current level are discarded
+type Level int32
+
+// Log levels in increasing order of severity
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// TraceLevel logs the traffic with deCONZ and influxdb, below every other level
+const TraceLevel = DebugLevel - 1
+
+var levelNames = map[Level]string{
+	TraceLevel: "trace",
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	if name, found := levelNames[l]; found {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// ParseLevel parses trace, debug, info, warn or error into a Level
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q, must be one of trace, debug, info, warn or error", s)
+}
+
+var current = int32(InfoLevel)
+
+// SetLevel sets the lowest level being logged
+func SetLevel(l Level) {
+	atomic.StoreInt32(&current, int32(l))
+}
+
+// GetLevel returns the lowest level being logged
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&current))
+}
+
+// Enabled reports if messages at level l are logged
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+func logf(l Level, format string, v ...interface{}) {
+	if Enabled(l) {
+		log.Output(3, fmt.Sprintf(format, v...))
+	}
+}
+
+// Tracef logs the traffic with deCONZ and influxdb, secrets registered with
+// Redact are replaced in the message
+func Tracef(format string, v ...interface{}) {
+	if Enabled(TraceLevel) {
+		log.Output(2, redact(fmt.Sprintf(format, v...)))
+	}
+}
+
+// redacted is written in place of secrets
+const redacted = "[redacted]"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// Redact registers a secret never to be traced, e.g. an api key, empty secrets are ignored
+func Redact(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+func redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	return s
+}
+
+// Debugf logs a message useful when diagnosing problems
+func Debugf(format string, v ...interface{}) {
+	logf(DebugLevel, format, v...)
+}
+
+// Infof logs a message about normal operation
+func Infof(format string, v ...interface{}) {
+	logf(InfoLevel, format, v...)
+}
+
+// Warnf logs a message about something that might need attention
+func Warnf(format string, v ...interface{}) {
+	logf(WarnLevel, format, v...)
+}
+
+// Errorf logs a message about something that failed
+func Errorf(format string, v ...interface{}) {
+	logf(ErrorLevel, format, v...)
+}
@@ -0,0 +1,32 @@
+package logging
+
+import "testing"
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"trace": TraceLevel, "debug": DebugLevel, "INFO": InfoLevel, "Warn": WarnLevel, "error": ErrorLevel} {
+		level, err := ParseLevel(name)
+		if err != nil {
+			t.Errorf("unable to parse %s: %s", name, err)
+		}
+		if level != expected {
+			t.Errorf("expected %s to be %s, got %s", name, expected, level)
+		}
+	}
+
+	_, err := ParseLevel("verbose")
+	if err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	defer SetLevel(GetLevel())
+
+	SetLevel(WarnLevel)
+	if Enabled(InfoLevel) {
+		t.Error("info should not be logged at warn level")
+	}
+	if !Enabled(ErrorLevel) {
+		t.Error("error should be logged at warn level")
+	}
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// redactedHeaders are never traced, whether or not their values were registered with Redact
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// TraceTransport traces every request and response with their bodies while
+// the trace level is enabled
+type TraceTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *TraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !Enabled(TraceLevel) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	dump, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		Tracef("unable to dump request to %s: %s", req.URL, err)
+	} else {
+		Tracef("request:\n%s", redactHeaders(string(dump)))
+	}
+
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		Tracef("request to %s failed: %s", req.URL, err)
+		return resp, err
+	}
+
+	dump, err = httputil.DumpResponse(resp, true)
+	if err != nil {
+		Tracef("unable to dump response from %s: %s", req.URL, err)
+	} else {
+		Tracef("response:\n%s", redactHeaders(string(dump)))
+	}
+	return resp, nil
+}
+
+// redactHeaders replaces the values of redactedHeaders in a dumped request or response
+func redactHeaders(dump string) string {
+	lines := strings.SplitAfter(dump, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			// the body follows the headers
+			break
+		}
+		for _, header := range redactedHeaders {
+			if strings.HasPrefix(strings.ToLower(line), strings.ToLower(header)+":") {
+				lines[i] = header + ": " + redacted + "\r\n"
+			}
+		}
+	}
+	return strings.Join(lines, "")
+}
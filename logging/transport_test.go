@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTraceTransport(t *testing.T) {
+	defer SetLevel(GetLevel())
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(append([]byte("echo "), body...))
+	}))
+	defer server.Close()
+
+	Redact("s3cr3t-key")
+	client := http.Client{Transport: &TraceTransport{RoundTripper: http.DefaultTransport}}
+	post := func() string {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/s3cr3t-key/sensors", strings.NewReader("temperature=21"))
+		req.Header.Set("Authorization", "Token influx-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	SetLevel(DebugLevel)
+	post()
+	if b.Len() > 0 {
+		t.Errorf("expected nothing to be traced at debug level, got %s", b.String())
+	}
+
+	SetLevel(TraceLevel)
+	// the bodies are still sent and received after tracing them
+	if body := post(); body != "echo temperature=21" {
+		t.Errorf("unexpected response %q", body)
+	}
+	traced := b.String()
+	for _, expected := range []string{"temperature=21", "echo temperature=21", "/api/[redacted]/sensors", "Authorization: [redacted]"} {
+		if !strings.Contains(traced, expected) {
+			t.Errorf("expected %s in trace %s", expected, traced)
+		}
+	}
+	for _, secret := range []string{"s3cr3t-key", "influx-token"} {
+		if strings.Contains(traced, secret) {
+			t.Errorf("expected %s to be redacted in trace %s", secret, traced)
+		}
+	}
+}
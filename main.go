@@ -1,214 +1,684 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/url"
+	"net/http"
 	"os"
-	"path"
+	"os/signal"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/logging"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	yaml "gopkg.in/yaml.v2"
 )
 
-// YmlFileName is the filename
-const YmlFileName = "deflux.yml"
+// version is set when building e.g. go build -ldflags "-X main.version=1.2.3"
+var version = "dev"
 
-// Configuration holds data for Deconz and influxdb configuration
-type Configuration struct {
-	Deconz    deconz.Config
-	Influxdb2 influxdb2ConfigProxy
-}
+var checkGatewayFlag = flag.Bool("check-gateway", false, "probe the gateway rest api once and exit, 0 if ok, 2 if unreachable and 3 if unauthorized")
+
+// exit codes of --check-gateway
+const (
+	exitGatewayOK           = 0
+	exitGatewayUnreachable  = 2
+	exitGatewayUnauthorized = 3
+)
+
+var logLevelFlag = flag.String("log-level", "", "log level overriding the configuration, one of trace, debug, info, warn or error")
+
+var traceFlag = flag.Bool("trace", false, "log every request to deconz and influxdb and every websocket frame with secrets redacted, same as --log-level trace")
 
 func main() {
-	config, err := loadConfiguration()
+	flag.Parse()
+
+	deconz.DefaultUserAgent = "deflux/" + version
+
+	// validate the flag before anything else, the configured level is applied once loaded
+	if *logLevelFlag != "" {
+		level, err := logging.ParseLevel(*logLevelFlag)
+		if err != nil {
+			log.Fatalf("invalid --log-level: %s", err)
+		}
+		logging.SetLevel(level)
+	}
+	if *traceFlag {
+		logging.SetLevel(logging.TraceLevel)
+	}
+
+	if flag.NArg() > 0 {
+		err := runCommand(flag.Args())
+		if err != nil {
+			log.Fatalf("%s: %s", flag.Arg(0), err)
+		}
+		return
+	}
+
+	config, err := loadFlagConfiguration()
+	if err != nil && (*configFlag != "" || *deconzConfigFlag != "" || *influxConfigFlag != "" || *checkGatewayFlag) {
+		log.Fatalf("unable to load configuration: %s", err)
+	}
 	if err != nil {
-		log.Printf("no configuration could be found: %s", err)
+		logging.Warnf("no configuration could be found: %s", err)
 		outputDefaultConfiguration()
 		return
 	}
 
-	sensorChan, err := sensorEventChan(config.Deconz)
+	if config.LogLevel != "" && *logLevelFlag == "" && !*traceFlag {
+		level, err := logging.ParseLevel(config.LogLevel)
+		if err != nil {
+			log.Fatalf("invalid loglevel in configuration: %s", err)
+		}
+		logging.SetLevel(level)
+	}
+
+	if *checkGatewayFlag {
+		os.Exit(checkGateway(config.Deconz))
+	}
+
+	err = validateConfiguration(config)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	// listen for signals before connecting, so a signal during startup is not lost
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	if !waitStartupDelay(config.StartupDelay, signals) {
+		return
+	}
+
+	if config.Influxdb2.Org == "" && config.Influxdb2.enabled() {
+		client := influxdb2.NewClientWithOptions(config.Influxdb2.URL, config.Influxdb2.Token, config.Influxdb2.options(nil))
+		config.Influxdb2.Org, err = detectOrg(client)
+		client.Close()
+		if err != nil {
+			log.Fatalf("no influxdb2 org configured and it could not be detected: %s", err)
+		}
+		logging.Infof("Using the influxdb2 org %s", config.Influxdb2.Org)
+	}
+
+	// the quarantine is written with a client of its own, as it receives
+	// frames as soon as the connection to deconz is established
+	var quarantined deconz.Quarantiner
+	var quarantineSink Sink
+	if config.Influxdb2.Quarantine.Enabled && config.Influxdb2.enabled() {
+		client := influxdb2.NewClientWithOptions(config.Influxdb2.URL, config.Influxdb2.Token, config.Influxdb2.quarantineInflux().options(nil))
+		q := newQuarantine(client, config.Influxdb2.Org, config.Influxdb2.Bucket, config.Influxdb2.Quarantine)
+		quarantined, quarantineSink = q, q.sink
+	}
+
+	if config.Tap.Addr != "" {
+		tap = newRawTap(config.Tap)
+		serveTap(config.Tap.Addr, tap)
+	}
+
+	sensorChan, sensorEventReader, err := sensorEventChan(config.Deconz, quarantined)
 	if err != nil {
 		panic(err)
 	}
 
-	log.Printf("Connected to deCONZ at %s", config.Deconz.Addr)
+	logging.Infof("Connected to deCONZ at %s", config.Deconz.Addr)
 
-	influxdbv2 := influxdb2.NewClientWithOptions(config.Influxdb2.URL, config.Influxdb2.Token,
-		influxdb2.DefaultOptions().SetBatchSize(config.Influxdb2.BatchSize))
-	writeAPI := influxdbv2.WriteAPI(config.Influxdb2.Org, config.Influxdb2.Bucket)
+	chatty = newChattyTracker(config.Chatty)
 
-	for {
+	if config.Metrics.Addr != "" {
+		serveMetrics(config.Metrics.Addr)
+	}
+	if config.Status.Addr != "" {
+		serveStatus(config.Status.Addr)
+	}
 
-		select {
-		case sensorEvent := <-sensorChan:
-			tags, fields, err := sensorEvent.Timeseries()
-			if err != nil {
-				log.Printf("not adding event to influx batch: %s", err)
-				continue
-			}
+	workers := config.Influxdb2.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var remote *remoteWriteSink
+	if config.RemoteWrite.URL != "" {
+		remote = newRemoteWriteSink(config.RemoteWrite)
+	}
+	if !config.Influxdb2.enabled() {
+		if remote == nil {
+			logging.Warnf("influxdb2 is disabled, events are read but not written")
+			workers = 0
+		} else {
+			logging.Infof("influxdb2 is disabled, events are only written to %s", config.RemoteWrite.URL)
+			workers = 1
+		}
+	}
 
-			writeAPI.WritePoint(influxdb2.NewPoint(
-				fmt.Sprintf("deflux_%s", sensorEvent.Sensor.Type),
-				tags,
-				fields,
-				time.Now(), // TODO: we should use the time associated with the event...
-			))
+	breaker := newCircuitBreaker(config.Influxdb2.CircuitBreaker)
+	dedupe := newDeduper(config.DedupeWindow)
+	deltas, err := newDeltas(config.Deltas)
+	if err != nil {
+		log.Fatalf("invalid deltas: %s", err)
+	}
 
+	var mapping fieldMapping
+	if config.FieldMapping != "" {
+		mapping, err = loadFieldMapping(config.FieldMapping)
+		if err != nil {
+			log.Fatalf("unable to load field mapping: %s", err)
 		}
 	}
-}
 
-func sensorEventChan(c deconz.Config) (chan *deconz.SensorEvent, error) {
-	// get an event reader from the API
-	d := deconz.API{Config: c}
-	reader, err := d.EventReader()
+	template, err := newMeasurementTemplate(config.Influxdb2.MeasurementTemplate, config.Influxdb2.Rooms)
 	if err != nil {
-		return nil, err
+		log.Fatalf("invalid measurement template: %s", err)
 	}
 
-	// Dial the reader
-	err = reader.Dial()
+	timezone, err := loadTimezone(config.Influxdb2.Timezone)
 	if err != nil {
-		return nil, err
+		log.Fatalf("invalid influxdb2 timezone: %s", err)
 	}
 
-	// create a new reader, embedding the event reader
-	sensorEventReader := d.SensorEventReader(reader)
-	channel := make(chan *deconz.SensorEvent)
-	// start it, it starts its own thread
-	sensorEventReader.Start(channel)
-	// return the channel
-	return channel, nil
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	sinks := make([]Sink, 0, workers+2)
+	// the client of the first worker tells if influxdb is healthy
+	var firstClient influxdb2.Client
+	for i := 0; i < workers; i++ {
+		var workerSinks []Sink
+		if config.Influxdb2.enabled() {
+			// the client keeps a single write api per bucket, so every worker needs
+			// its own client to batch independently
+			influxdbv2 := influxdb2.NewClientWithOptions(config.Influxdb2.URL, config.Influxdb2.Token,
+				config.Influxdb2.options(breaker))
+			if firstClient == nil {
+				firstClient = influxdbv2
+			}
+			var sink Sink
+			if config.Influxdb2.Blocking {
+				sink = newBlockingInfluxSink(influxdbv2, config.Influxdb2.Org, config.Influxdb2.Bucket)
+			} else {
+				sink = newInfluxSink(influxdbv2, config.Influxdb2.Org, config.Influxdb2.Bucket)
+			}
+			sinks = append(sinks, sink)
+			workerSinks = append(workerSinks, sink)
+		}
+		// the remote write sink is shared by the workers
+		if remote != nil {
+			workerSinks = append(workerSinks, remote)
+		}
+		w := &eventWriter{
+			sinks:   workerSinks,
+			breaker: breaker,
+			dedupe:  dedupe,
+			deltas:  deltas,
+
+			sentinels: config.Sentinels,
+
+			singleMeasurement: config.Influxdb2.SingleMeasurement,
+			fieldMapping:      mapping,
+			template:          template,
+			sensorMeasurement: config.Influxdb2.SensorMeasurement,
+
+			measurementPerField: config.Influxdb2.MeasurementPerField,
+			singleFieldName:     config.Influxdb2.SingleFieldName,
+			precisions:          config.Influxdb2.Precisions,
+			timezone:            timezone,
+			limits:              config.Influxdb2.Limits,
+			allowlist:           newMeasurementAllowlist(config.Influxdb2.Measurements),
+
+			quarantine: quarantined,
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(sensorChan, stop)
+		}()
+	}
+
+	if remote != nil {
+		sinks = append(sinks, remote)
+	}
+	if quarantineSink != nil {
+		sinks = append(sinks, quarantineSink)
+	}
+
+	if workers == 0 {
+		// keep reading events so the connection to deconz stays healthy
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			discard(sensorChan, stop)
+		}()
+	}
+
+	// the annotations are batched along with the points of the first worker
+	var annotations Sink
+	if config.Influxdb2.Annotations && firstClient != nil {
+		annotations = sinks[0]
+		annotate(annotations, "started")
+	}
+
+	if config.MaxSilence > 0 {
+		go watchSilence(status, config.MaxSilence, stop)
+	}
+
+	notifier := newSystemdNotify()
+	if config.SystemdNotify {
+		go notifier.ready(func() bool { return !connection.since().IsZero() }, status, stop)
+	}
+
+	sig := <-signals
+	logging.Infof("Received %s, shutting down", sig)
+	if config.SystemdNotify {
+		err = notifier.notify("STOPPING=1")
+		if err != nil {
+			logging.Warnf("%s", err)
+		}
+	}
+
+	sensorEventReader.StopReadEvents()
+	close(stop)
+	if annotations != nil {
+		annotate(annotations, "stopped")
+	}
+	shutdown(config.shutdownTimeout(), &wg, sinks)
 }
 
-func loadConfiguration() (*Configuration, error) {
-	data, err := readConfiguration()
-	if err != nil {
-		return nil, fmt.Errorf("could not read configuration: %s", err)
+// eventWriter writes sensor events to its sinks
+type eventWriter struct {
+	// sinks are written to in turn
+	sinks []Sink
+	// breaker, dedupe and deltas are optional
+	breaker *circuitBreaker
+	dedupe  *deduper
+	deltas  *deltas
+
+	// sentinels omits fields reporting no reading, it may be nil
+	sentinels sentinels
+
+	// singleMeasurement writes every sensor type to the same measurement
+	singleMeasurement bool
+	// fieldMapping renames fields before writing, it may be nil
+	fieldMapping fieldMapping
+	// template names measurements instead of the sensor type, it may be nil
+	template *measurementTemplate
+	// sensorMeasurement overrides the measurement of sensors by id
+	sensorMeasurement map[string]string
+	// measurementPerField writes every field as its own measurement with a
+	// single field named by singleFieldName
+	measurementPerField bool
+	singleFieldName     string
+	// precisions truncates timestamps per measurement, it may be nil
+	precisions precisions
+	limits     pointLimits
+	allowlist  measurementAllowlist
+	// timezone shifts timestamps to its wall clock time, nil writes UTC
+	timezone *time.Location
+	// quarantine receives the events that could not be converted to points,
+	// it may be nil
+	quarantine deconz.Quarantiner
+}
+
+// run writes sensor events from sensorChan to the sinks until stop is closed,
+// the events still buffered in sensorChan are written before returning
+func (w *eventWriter) run(sensorChan chan *deconz.SensorEvent, stop <-chan struct{}) {
+	for {
+		var sensorEvent *deconz.SensorEvent
+		select {
+		case sensorEvent = <-sensorChan:
+		case <-stop:
+			w.drain(sensorChan)
+			return
+		}
+
+		w.process(sensorEvent)
 	}
+}
 
-	var config Configuration
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse configuration: %s", err)
+// drain writes the events buffered in sensorChan until it is empty
+func (w *eventWriter) drain(sensorChan chan *deconz.SensorEvent) {
+	drained := 0
+	for {
+		select {
+		case sensorEvent := <-sensorChan:
+			w.process(sensorEvent)
+			drained++
+		default:
+			if drained > 0 {
+				logging.Infof("Wrote %d events buffered when stopping", drained)
+			}
+			return
+		}
 	}
-	return &config, nil
 }
 
-// readConfiguration tries to read pwd/deflux.yml or /etc/deflux.yml
-func readConfiguration() ([]byte, error) {
-	// first try to load ${pwd}/deflux.yml
-	pwd, err := os.Getwd()
+// process writes a single sensor event, a panic while converting it is
+// logged along with the offending event instead of crashing deflux
+func (w *eventWriter) process(sensorEvent *deconz.SensorEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			processPanics.Inc()
+			logging.Errorf("recovered from panic processing event %s: %v\n%s", describeEvent(sensorEvent), r, debug.Stack())
+		}
+	}()
+
+	// state kept per sensor is keyed by its id rather than the rendered id tag
+	sensor := sensorEvent.SensorID()
+
+	status.event(sensorEvent.Sensor.Type, time.Now())
+	chatty.event(sensor, sensorEvent.Sensor.Name, time.Now())
+
+	tags, fields, err := sensorEvent.Timeseries()
 	if err != nil {
-		return nil, fmt.Errorf("unable to get current work directory: %s", err)
+		logging.Infof("not adding event to influx batch: %s", err)
+		w.quarantineEvent(sensorEvent, err)
+		return
 	}
 
-	pwdPath := path.Join(pwd, YmlFileName)
-	data, pwdErr := ioutil.ReadFile(pwdPath)
-	if pwdErr == nil {
-		log.Printf("Using configuration %s", pwdPath)
-		return data, nil
+	w.sentinels.omit(sensorEvent.Sensor.Type, fields)
+	if len(fields) == 0 {
+		logging.Debugf("not adding event to influx batch: every field of %s holds a sentinel", describeEvent(sensorEvent))
+		return
+	}
+
+	if w.deltas != nil {
+		w.deltas.add(sensor, fields)
+	}
+
+	fields = w.fieldMapping.rename(sensorEvent.Sensor.Type, fields)
+
+	if w.limits.exceeded(sensor, tags, fields) {
+		return
+	}
+
+	if w.dedupe != nil && w.dedupe.duplicate(sensor, fields, time.Now()) {
+		dedupedEvents.Inc()
+		return
 	}
 
-	// if we reached this code, we where unable to read a "local" Configuration
-	// try from /etc/deflux.yml
-	etcPath := path.Join("/etc", YmlFileName)
-	data, etcErr := ioutil.ReadFile(etcPath)
-	if etcErr != nil {
-		return nil, fmt.Errorf("\n%s\n%s", pwdErr, etcErr)
+	if w.breaker != nil && w.breaker.config.Drop && w.breaker.dropping() {
+		breakerDroppedPoints.Inc()
+		return
 	}
 
-	log.Printf("Using configuration %s", etcPath)
-	return data, nil
+	measurement := measurementFor(sensorEvent.Sensor.Type, w.singleMeasurement)
+	if w.template != nil {
+		measurement = w.template.name(sensorEvent.Sensor, sensor, measurement)
+	}
+	if m := w.sensorMeasurement[sensor]; m != "" {
+		measurement = m
+	}
+	ts := inTimezone(sensorEvent.Timestamp(time.Now()), w.timezone)
+
+	if !w.measurementPerField {
+		if !w.allowlist.allows(measurement, measurement) {
+			logging.Debugf("not writing %s, it is not in the measurement allowlist", measurement)
+			return
+		}
+		w.write(sensor, measurement, tags, fields, w.precisions.truncate(measurement, measurement, ts))
+		return
+	}
+	for field, value := range fields {
+		fieldMeasurement := measurement + "_" + field
+		if !w.allowlist.allows(fieldMeasurement, measurement) {
+			logging.Debugf("not writing %s, it is not in the measurement allowlist", fieldMeasurement)
+			continue
+		}
+		w.write(sensor, fieldMeasurement, tags, map[string]interface{}{singleFieldName(w.singleFieldName, field): value}, w.precisions.truncate(fieldMeasurement, measurement, ts))
+	}
 }
 
-// influxdbConfigProxy proxies the influxdbv2 config into a yml capable
-// struct, its only used for encoding to yml as the yml package
-// have no problem skipping the Proxy field when decoding
-type influxdb2ConfigProxy struct {
-	URL       string
-	Org       string
-	Token     string
-	Bucket    string
-	BatchSize uint
+// quarantineEvent hands the frame of an event that could not be converted to
+// the quarantine, if it is set
+func (w *eventWriter) quarantineEvent(sensorEvent *deconz.SensorEvent, err error) {
+	if w.quarantine == nil {
+		return
+	}
+	frame, ferr := eventFrame(sensorEvent)
+	if ferr != nil {
+		logging.Warnf("unable to quarantine event of %s: %s", sensorEvent.Sensor.Name, ferr)
+		return
+	}
+	w.quarantine.Quarantine(frame, conversionError{err})
 }
 
-func outputDefaultConfiguration() {
+// describeEvent formats the payload of an event for logs, it copes with
+// incomplete events as it is used when processing them failed
+func describeEvent(sensorEvent *deconz.SensorEvent) string {
+	if sensorEvent == nil || sensorEvent.Event == nil {
+		return "<nil>"
+	}
+	e := sensorEvent.Event
+	return fmt.Sprintf("%s/%d state=%s config=%s", e.Resource, e.ID, e.RawState, e.Config)
+}
 
-	c := defaultConfiguration()
+// discard reads sensor events from sensorChan without writing them until stop is closed
+func discard(sensorChan chan *deconz.SensorEvent, stop <-chan struct{}) {
+	for {
+		select {
+		case e := <-sensorChan:
+			status.event(e.Sensor.Type, time.Now())
+			chatty.event(e.SensorID(), e.Sensor.Name, time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
 
-	// try to pair with deconz
-	u, err := url.Parse(c.Deconz.Addr)
-	if err == nil {
-		apikey, err := deconz.Pair(*u)
+// write hands a point of sensor to the write api
+func (w *eventWriter) write(sensor, measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) {
+	if logging.Enabled(logging.DebugLevel) {
+		logging.Debugf("writing %s", describePoint(measurement, tags, fields, ts))
+	}
+
+	p := influxdb2.NewPoint(measurement, tags, fields, ts)
+	if w.limits.oversized(sensor, p) {
+		return
+	}
+	for _, s := range w.sinks {
+		err := s.Write(p)
 		if err != nil {
-			log.Printf("unable to pair with deconz: %s, please fill out APIKey manually", err)
-		}
-		c.Deconz.APIKey = string(apikey)
-	}
-
-	// we need to use a proxy struct to encode yml as the influxdb client configuration struct
-	// includes a Proxy: func() field that the yml encoder cannot handle
-	yml, err := yaml.Marshal(struct {
-		Deconz    deconz.Config
-		Influxdb2 influxdb2ConfigProxy
-	}{
-		Deconz: c.Deconz,
-		Influxdb2: influxdb2ConfigProxy{
-			URL:       c.Influxdb2.URL,
-			Org:       c.Influxdb2.Org,
-			Token:     c.Influxdb2.Token,
-			Bucket:    c.Influxdb2.Bucket,
-			BatchSize: c.Influxdb2.BatchSize,
-		},
-	})
+			logging.Errorf("unable to write %s: %s", measurement, err)
+		}
+	}
+}
+
+// annotate writes a deflux_event point tagged with a lifecycle event of
+// deflux such as started, for annotating dashboards
+func annotate(sink Sink, event string) {
+	err := sink.Write(influxdb2.NewPoint("deflux_event",
+		map[string]string{"event": event},
+		map[string]interface{}{"version": version},
+		time.Now(),
+	))
 	if err != nil {
-		log.Fatalf("unable to generate default configuration: %s", err)
+		logging.Errorf("unable to write %s annotation: %s", event, err)
 	}
+}
 
-	log.Printf("Outputting default configuration, save this to /etc/deflux.yml")
-	// to stdout
-	fmt.Print(string(yml))
+// describePoint formats a point for debug logs, tags and fields are sorted and
+// fields include their type as influxdb treats e.g. int64 and float64 differently
+func describePoint(measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) string {
+	var sb strings.Builder
+	sb.WriteString(measurement)
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb.WriteString(" tags")
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%s", k, tags[k])
+	}
+
+	keys = keys[:0]
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb.WriteString(" fields")
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%T(%v)", k, fields[k], fields[k])
+	}
+
+	fmt.Fprintf(&sb, " at %s", ts.Format(time.RFC3339Nano))
+	return sb.String()
 }
 
-func defaultConfiguration() *Configuration {
-	// this is the default configuration
-	c := Configuration{
-		Deconz: deconz.Config{
-			Addr:   "http://127.0.0.1:8080/",
-			APIKey: "change me",
-		},
-		Influxdb2: influxdb2ConfigProxy{
-			URL:       "http://127.0.0.1:8086/",
-			Org:       "change me",
-			Token:     "change me",
-			Bucket:    "change me",
-			BatchSize: 20,
-		},
+// checkGateway probes the gateway once and returns the exit code of --check-gateway
+func checkGateway(c deconz.Config) int {
+	d := deconz.API{Config: c}
+	err := d.Check()
+	if err == deconz.ErrUnauthorized {
+		logging.Errorf("gateway %s is unauthorized: %s", c.Addr, err)
+		return exitGatewayUnauthorized
+	}
+	if err != nil {
+		logging.Errorf("gateway %s is unreachable: %s", c.Addr, err)
+		return exitGatewayUnreachable
+	}
+	logging.Infof("gateway %s is ok", c.Addr)
+	return exitGatewayOK
+}
+
+func sensorEventChan(c deconz.Config, quarantine deconz.Quarantiner) (chan *deconz.SensorEvent, *deconz.SensorEventReader, error) {
+	// get an event reader from the API, polling the rest api if a poll interval is configured
+	d := deconz.API{Config: c}
+	var reader deconz.EventReader
+	var err error
+	if c.PollInterval > 0 {
+		reader = d.PollingReader()
+		if tap != nil {
+			logging.Warnf("the tap sends no frames while polling the rest api")
+		}
+	} else {
+		wsReader, err := d.EventReader()
+		if err != nil {
+			return nil, nil, err
+		}
+		if tap != nil {
+			wsReader.Tap = tap.broadcast
+		}
+		reader = wsReader
 	}
 
-	// lets see if we are able to discover a gateway, and overwrite parts of the
-	// default congfiguration
-	discovered, err := deconz.Discover()
+	// create a new reader, embedding the event reader
+	sensorEventReader := d.SensorEventReader(reader)
+	sensorEventReader.Quarantine = quarantine
+	observeReader(sensorEventReader)
+	channel := make(chan *deconz.SensorEvent, c.EventBuffer)
+	// start it, it connects before starting its own thread
+	err = sensorEventReader.Start(channel)
 	if err != nil {
-		log.Printf("discovery of deconz gateway failed: %s, please fill configuration manually..", err)
-		return &c
+		return nil, nil, err
 	}
+	// return the channel
+	return channel, sensorEventReader, nil
+}
 
-	// TODO: discover is actually a slice of multiple discovered gateways,
-	// but for now we use only the first available
-	deconz := discovered[0]
-	addr := url.URL{
-		Scheme: "http",
-		Host:   fmt.Sprintf("%s:%d", deconz.InternalIPAddress, deconz.InternalPort),
-		Path:   "/api",
+// influxdbConfigProxy proxies the influxdbv2 config into a yml capable
+// struct, its only used for encoding to yml as the yml package
+// have no problem skipping the Proxy field when decoding
+type influxdb2ConfigProxy struct {
+	// Enabled switches writing to influxdb off when false, defaults to true
+	Enabled       *bool
+	URL           string
+	Org           string
+	Token         string
+	Bucket        string
+	BatchSize     uint
+	FlushInterval time.Duration
+	// TokenFile holds the token instead of Token, it is read again when
+	// influxdb rejects the token so it can be rotated without a restart
+	TokenFile string
+	// Workers is the number of goroutines writing to influxdb, each batching on its own
+	Workers        int
+	CircuitBreaker circuitBreakerConfig
+	// SingleMeasurement writes every sensor to one deflux measurement instead
+	// of one measurement per sensor type, the type is still available as a tag
+	SingleMeasurement bool
+	// MeasurementPerField writes every field to its own measurement named after
+	// the sensor measurement and the field, e.g. deflux_ZHATemperature_temperature
+	MeasurementPerField bool
+	// SingleFieldName names the only field of measurements written per field,
+	// {field} is replaced by the original field name, defaults to value
+	SingleFieldName string
+	// WritePath replaces the path points are written to, for influx compatible
+	// endpoints such as VictoriaMetrics
+	WritePath string
+	// AuthScheme replaces the Token scheme sent with the token, e.g. Bearer, or
+	// Basic with the token as user:password
+	AuthScheme string
+	// Annotations writes a deflux_event point tagged started or stopped when
+	// deflux starts and stops gracefully
+	Annotations bool
+	// Precision of the timestamps written, one of 1ns (default), 1us, 1ms or 1s
+	Precision time.Duration
+	// Precisions truncates the timestamps of single measurements, the coarser
+	// of it and Precision is written
+	Precisions precisions
+	// Limits drops points of sensors emitting excessive fields or tags
+	Limits pointLimits
+	// Quarantine writes the frames from deconz that could not be mapped
+	Quarantine quarantineConfig
+	// Measurements lists the only measurements written if set
+	Measurements []string
+	// Blocking writes every point before the next event is processed instead
+	// of batching them in the background
+	Blocking bool
+	// MeasurementTemplate is a go template naming the measurement of an event,
+	// Rooms maps sensor names to the room available to it
+	MeasurementTemplate string
+	Rooms               map[string]string
+	// Timezone writes timestamps as the wall clock time of a timezone, e.g.
+	// Local or Europe/Berlin, instead of UTC
+	Timezone string
+	// Headers are sent with every request to influxdb, e.g. for a proxy
+	Headers map[string]string
+	// SensorMeasurement names the measurement of single sensors by their id,
+	// taking precedence over every other way of naming measurements
+	SensorMeasurement map[string]string
+	// Connections tunes the pool of connections to influxdb
+	Connections connectionPool
+}
+
+// enabled reports if points should be written to influxdb
+func (c influxdb2ConfigProxy) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// options returns influxdb client options for the configuration, the http client
+// is instrumented to observe the batches written and guarded by breaker if not nil
+func (c influxdb2ConfigProxy) options(breaker *circuitBreaker) *influxdb2.Options {
+	options := influxdb2.DefaultOptions().SetBatchSize(c.BatchSize)
+	if c.Precision > 0 {
+		options.SetPrecision(c.Precision)
+	}
+	if c.FlushInterval > 0 {
+		options.SetFlushInterval(uint(c.FlushInterval / time.Millisecond))
 	}
-	c.Deconz.Addr = addr.String()
 
-	return &c
+	var transport http.RoundTripper = &endpointTransport{
+		RoundTripper: &logging.TraceTransport{RoundTripper: c.Connections.transport()},
+		writePath:    c.WritePath,
+		authScheme:   c.AuthScheme,
+		headers:      c.Headers,
+	}
+	if c.TokenFile != "" {
+		transport = &tokenTransport{RoundTripper: transport, file: c.TokenFile}
+	}
+	transport = &batchTransport{RoundTripper: transport, batchSize: c.BatchSize}
+	if breaker != nil {
+		transport = &breakerTransport{RoundTripper: transport, breaker: breaker}
+	}
+
+	options.SetHTTPClient(&http.Client{
+		Timeout:   time.Duration(options.HTTPRequestTimeout()) * time.Second,
+		Transport: transport,
+	})
+
+	return options
 }
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/internal/deconztest"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+const gatewaySensors = `{"5":{"name":"Kitchen","type":"ZHATemperature","config":{"on":true},"state":{"temperature":2150,"lastupdated":"2018-03-13T19:46:03"}}}`
+
+const gatewayTemperatureEvent = `{"e":"changed","id":"5","r":"sensors","state":{"temperature":2200,"lastupdated":"2018-03-13T19:47:03"},"t":"event"}`
+
+// gatewayConfigEvent is a config change captured from a gateway
+const gatewayConfigEvent = `{"config":{"battery":90,"on":true,"reachable":false},"e":"changed","id":"5","r":"sensors","t":"event","uniqueid":"00:15:8d:00:01:2b:48:b6-01-0402"}`
+
+func TestEndToEnd(t *testing.T) {
+	gateway := deconztest.NewGateway("secret", gatewaySensors)
+	defer gateway.Close()
+
+	lines := make(chan string, 1)
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasSuffix(r.URL.Path, "/write") {
+			lines <- string(body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	sensorChan, _, err := sensorEventChan(deconz.Config{Addr: gateway.Addr(), APIKey: "secret"}, nil)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	c := influxdb2ConfigProxy{URL: influx.URL, Org: "org", Bucket: "bucket", BatchSize: 1}
+	client := influxdb2.NewClientWithOptions(c.URL, "token", c.options(nil))
+	defer client.Close()
+	w := &eventWriter{sinks: []Sink{newInfluxSink(client, c.Org, c.Bucket)}}
+	go w.run(sensorChan, nil)
+
+	for _, c := range []struct {
+		event    string
+		expected string
+	}{
+		{gatewayTemperatureEvent, "deflux_ZHATemperature,id=5,name=Kitchen,type=ZHATemperature temperature=22 "},
+		// battery and reachability are sent in the config, without a state
+		{gatewayConfigEvent, "deflux_ZHATemperature,id=5,name=Kitchen,type=ZHATemperature battery=90i,reachable=false "},
+	} {
+		gateway.Send(c.event)
+
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, c.expected) {
+				t.Errorf("expected line protocol %q, got %q", c.expected, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("no point was written to influxdb for %s", c.event)
+		}
+	}
+}
+
+func TestCheckGateway(t *testing.T) {
+	gateway := deconztest.NewGateway("secret", gatewaySensors)
+
+	if code := checkGateway(deconz.Config{Addr: gateway.Addr(), APIKey: "secret"}); code != exitGatewayOK {
+		t.Errorf("expected exit code %d, got %d", exitGatewayOK, code)
+	}
+	if code := checkGateway(deconz.Config{Addr: gateway.Addr(), APIKey: "wrong"}); code != exitGatewayUnauthorized {
+		t.Errorf("expected exit code %d, got %d", exitGatewayUnauthorized, code)
+	}
+
+	gateway.Close()
+	if code := checkGateway(deconz.Config{Addr: gateway.Addr(), APIKey: "secret"}); code != exitGatewayUnreachable {
+		t.Errorf("expected exit code %d, got %d", exitGatewayUnreachable, code)
+	}
+}
+
+func TestDescribePoint(t *testing.T) {
+	ts := time.Date(2018, 3, 13, 19, 47, 3, 0, time.UTC)
+	description := describePoint("deflux_ZHATemperature",
+		map[string]string{"type": "ZHATemperature", "id": "5", "name": "Kitchen"},
+		map[string]interface{}{"temperature": 22.0, "battery": int64(90)}, ts)
+
+	expected := "deflux_ZHATemperature tags id=5 name=Kitchen type=ZHATemperature fields battery=int64(90) temperature=float64(22) at 2018-03-13T19:47:03Z"
+	if description != expected {
+		t.Errorf("expected %q, got %q", expected, description)
+	}
+}
+
+// testSink collects the points written to it and records being flushed and closed
+type testSink struct {
+	points          []*write.Point
+	flushed, closed bool
+}
+
+func (s *testSink) Write(p *write.Point) error {
+	s.points = append(s.points, p)
+	return nil
+}
+
+func (s *testSink) Flush(ctx context.Context) error {
+	s.flushed = true
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+type temperatureLookup struct{}
+
+func (temperatureLookup) LookupType(int) (string, error) { return "ZHATemperature", nil }
+
+// writeEvent runs w until it has written payload
+func writeEvent(t *testing.T, w *eventWriter, payload string) {
+	d := event.Decoder{TypeStore: temperatureLookup{}}
+	e, err := d.Parse([]byte(payload))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	sensorChan := make(chan *deconz.SensorEvent)
+	stop := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		w.run(sensorChan, stop)
+		done <- true
+	}()
+	sensorChan <- &deconz.SensorEvent{Event: e, Sensor: &deconz.Sensor{Name: "Kitchen", Type: "ZHATemperature"}}
+	close(stop)
+	<-done
+}
+
+// gatewayEvent reads payload from a gateway with timeseries configured, so
+// the event carries its timeseries options
+func gatewayEvent(t *testing.T, timeseries deconz.TimeseriesOptions, payload string) *deconz.SensorEvent {
+	gateway := deconztest.NewGateway("secret", gatewaySensors)
+	defer gateway.Close()
+
+	d := deconz.API{Config: deconz.Config{Addr: gateway.Addr(), APIKey: "secret", Timeseries: timeseries}}
+	eventReader, err := d.EventReader()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	reader := d.SensorEventReader(eventReader)
+	sensorChan := make(chan *deconz.SensorEvent, 1)
+	err = reader.Start(sensorChan)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer reader.StopReadEvents()
+
+	gateway.Send(payload)
+	select {
+	case e := <-sensorChan:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event was read for %s", payload)
+	}
+	return nil
+}
+
+func TestEventWriterTemplateID(t *testing.T) {
+	sink := &testSink{}
+	template, _ := newMeasurementTemplate("sensor_{{.ID}}", nil)
+	w := &eventWriter{sinks: []Sink{sink}, template: template}
+	w.process(gatewayEvent(t, deconz.TimeseriesOptions{TagPrefix: "home-"}, gatewayTemperatureEvent))
+
+	if len(sink.points) != 1 || sink.points[0].Name() != "sensor_5" {
+		t.Errorf("expected the template to be given the id of the sensor, got %v", sink.points)
+	}
+}
+
+func TestEventWriterMeasurementPerField(t *testing.T) {
+	sink := &testSink{}
+	w := &eventWriter{sinks: []Sink{sink}, measurementPerField: true}
+	writeEvent(t, w, gatewayTemperatureEvent)
+
+	if len(sink.points) != 1 {
+		t.Fatalf("expected a single point, got %d", len(sink.points))
+	}
+	line := write.PointToLineProtocol(sink.points[0], time.Nanosecond)
+	if !strings.HasPrefix(line, "deflux_ZHATemperature_temperature,id=5,name=Kitchen,type=ZHATemperature value=22 ") {
+		t.Errorf("unexpected line protocol %q", line)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	sink := &testSink{}
+	annotate(sink, "started")
+
+	if len(sink.points) != 1 {
+		t.Fatalf("expected a single point, got %d", len(sink.points))
+	}
+	line := write.PointToLineProtocol(sink.points[0], time.Nanosecond)
+	if !strings.HasPrefix(line, `deflux_event,event=started version="dev" `) {
+		t.Errorf("unexpected line protocol %q", line)
+	}
+}
+
+func TestInfluxdb2Enabled(t *testing.T) {
+	var c influxdb2ConfigProxy
+	if !c.enabled() {
+		t.Error("expected influxdb2 to be enabled by default")
+	}
+
+	enabled := false
+	c.Enabled = &enabled
+	if c.enabled() {
+		t.Error("expected influxdb2 to be disabled")
+	}
+}
+
+// panickingState panics when its fields are read
+type panickingState struct{}
+
+func (panickingState) Fields() map[string]interface{} {
+	panic("malformed state")
+}
+
+func TestEventWriterRecovers(t *testing.T) {
+	sink := &testSink{}
+	w := &eventWriter{sinks: []Sink{sink}}
+
+	sensorChan := make(chan *deconz.SensorEvent)
+	stop := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		w.run(sensorChan, stop)
+		done <- true
+	}()
+
+	sensor := &deconz.Sensor{Name: "Kitchen", Type: "ZHATemperature"}
+	sensorChan <- &deconz.SensorEvent{Event: &event.Event{Resource: "sensors", ID: 5, State: panickingState{}}, Sensor: sensor}
+
+	// the writer keeps processing the following events
+	d := event.Decoder{TypeStore: temperatureLookup{}}
+	e, err := d.Parse([]byte(gatewayTemperatureEvent))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	sensorChan <- &deconz.SensorEvent{Event: e, Sensor: sensor}
+	close(stop)
+	<-done
+
+	if len(sink.points) != 1 {
+		t.Errorf("expected the event after the panic to be written, got %d points", len(sink.points))
+	}
+}
+
+func TestEventWriterSinks(t *testing.T) {
+	sinks := []*testSink{{}, {}}
+	w := &eventWriter{sinks: []Sink{sinks[0], sinks[1]}}
+	writeEvent(t, w, gatewayTemperatureEvent)
+
+	for i, s := range sinks {
+		if len(s.points) != 1 {
+			t.Errorf("expected a point to be written to sink %d, got %d", i, len(s.points))
+		}
+	}
+}
+
+func TestEventWriterAllowlist(t *testing.T) {
+	sink := &testSink{}
+	w := &eventWriter{sinks: []Sink{sink}, allowlist: newMeasurementAllowlist([]string{"deflux_ZHAHumidity"})}
+	writeEvent(t, w, gatewayTemperatureEvent)
+
+	if len(sink.points) != 0 {
+		t.Errorf("expected measurements not allowed to be dropped, got %d points", len(sink.points))
+	}
+}
+
+func TestEventWriterSensorMeasurement(t *testing.T) {
+	sink := &testSink{}
+	template, _ := newMeasurementTemplate("{{.Name}}", nil)
+	w := &eventWriter{sinks: []Sink{sink}, template: template, sensorMeasurement: map[string]string{"5": "kitchen_temperature", "6": "other"}}
+	writeEvent(t, w, gatewayTemperatureEvent)
+
+	if len(sink.points) != 1 || sink.points[0].Name() != "kitchen_temperature" {
+		t.Errorf("expected the measurement of sensor 5 to be overridden, got %v", sink.points)
+	}
+}
+
+func TestEventWriterSensorMeasurementTagPrefix(t *testing.T) {
+	sink := &testSink{}
+	w := &eventWriter{sinks: []Sink{sink}, sensorMeasurement: map[string]string{"5": "kitchen_temperature"}}
+	w.process(gatewayEvent(t, deconz.TimeseriesOptions{TagPrefix: "home-", TagSuffix: "-1"}, gatewayTemperatureEvent))
+
+	if len(sink.points) != 1 || sink.points[0].Name() != "kitchen_temperature" {
+		t.Errorf("expected the measurement of sensor 5 to be overridden despite the affixed id tag, got %v", sink.points)
+	}
+}
+
+func TestEventWriterResourceIDs(t *testing.T) {
+	d := event.Decoder{TypeStore: temperatureLookup{}}
+	sensorEvent, err := d.Parse([]byte(gatewayTemperatureEvent))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	lightEvent, err := d.Parse([]byte(`{"e":"changed","id":"5","r":"lights","state":{"temperature":22},"t":"event"}`))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	// light 5 shares neither the dedupe state nor the measurement of sensor 5
+	sink := &testSink{}
+	w := &eventWriter{sinks: []Sink{sink}, dedupe: newDeduper(time.Hour), sensorMeasurement: map[string]string{"lights/5": "lamp"}}
+	w.process(&deconz.SensorEvent{Event: sensorEvent, Sensor: &deconz.Sensor{Name: "Kitchen", Type: "ZHATemperature"}})
+	w.process(&deconz.SensorEvent{Event: lightEvent, Sensor: &deconz.Sensor{Name: "lights/5", Type: "lights"}})
+
+	if len(sink.points) != 2 {
+		t.Fatalf("expected a point of the sensor and of the light, got %v", sink.points)
+	}
+	if sink.points[0].Name() != "deflux_ZHATemperature" || sink.points[1].Name() != "lamp" {
+		t.Errorf("expected only the measurement of the light to be overridden, got %s and %s", sink.points[0].Name(), sink.points[1].Name())
+	}
+}
+
+func TestEventWriterQuarantine(t *testing.T) {
+	d := event.Decoder{TypeStore: temperatureLookup{}}
+	e, err := d.Parse([]byte(`{"e":"changed","id":"3","r":"lights","state":{"alert":"none"},"t":"event"}`))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	// the light has no numeric or boolean state to write
+	sink, quarantined := &testSink{}, &testSink{}
+	w := &eventWriter{sinks: []Sink{sink}, quarantine: &quarantine{sink: quarantined, measurement: defaultQuarantineMeasurement}}
+	w.process(&deconz.SensorEvent{Event: e, Sensor: &deconz.Sensor{Name: "lights/3", Type: "lights"}})
+
+	if len(sink.points) != 0 || len(quarantined.points) != 1 {
+		t.Fatalf("expected the event to be quarantined only, got %d points and %d quarantined", len(sink.points), len(quarantined.points))
+	}
+	line := write.PointToLineProtocol(quarantined.points[0], time.Nanosecond)
+	expected := `deflux_quarantine,reason=conversion_error _raw="{\"t\":\"event\",\"e\":\"changed\",\"r\":\"lights\",\"id\":\"3\",\"state\":{\"alert\":\"none\"}}",error="this event`
+	if !strings.HasPrefix(line, expected) {
+		t.Errorf("expected line protocol starting with %q, got %q", expected, line)
+	}
+}
+
+func TestEventWriterDrains(t *testing.T) {
+	d := event.Decoder{TypeStore: temperatureLookup{}}
+	sensorChan := make(chan *deconz.SensorEvent, 2)
+	for i := 0; i < 2; i++ {
+		e, err := d.Parse([]byte(gatewayTemperatureEvent))
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		sensorChan <- &deconz.SensorEvent{Event: e, Sensor: &deconz.Sensor{Name: "Kitchen", Type: "ZHATemperature"}}
+	}
+
+	// events buffered when stopping are still written
+	sink := &testSink{}
+	w := &eventWriter{sinks: []Sink{sink}}
+	stop := make(chan struct{})
+	close(stop)
+	w.run(sensorChan, stop)
+
+	if len(sink.points) != 2 || len(sensorChan) != 0 {
+		t.Errorf("expected the 2 buffered events to be written, got %d points and %d left", len(sink.points), len(sensorChan))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// singleMeasurement is the measurement every point is written to in single measurement mode
+const singleMeasurement = "deflux"
+
+// measurementNames caches influxdb measurement names keyed by sensor type
+var measurementNames sync.Map
+
+// measurementName returns the influxdb measurement used for a sensor type,
+// formatting it only the first time a type is seen
+func measurementName(sensorType string) string {
+	if name, ok := measurementNames.Load(sensorType); ok {
+		return name.(string)
+	}
+
+	name := "deflux_" + sensorType
+	measurementNames.Store(sensorType, name)
+	return name
+}
+
+// defaultSingleFieldName is the field of measurements written per field unless configured
+const defaultSingleFieldName = "value"
+
+// measurementFor returns the measurement a point from sensorType is written to,
+// single puts every sensor type in one measurement distinguished by the type tag
+func measurementFor(sensorType string, single bool) string {
+	if single {
+		return singleMeasurement
+	}
+	return measurementName(sensorType)
+}
+
+// singleFieldName returns the name of field in a measurement written per field,
+// {field} in the configured name is replaced by the original field name
+func singleFieldName(configured, field string) string {
+	if configured == "" {
+		return defaultSingleFieldName
+	}
+	return strings.Replace(configured, "{field}", field, -1)
+}
+
+// measurementAllowlist holds the only measurements written, a nil allowlist allows all of them
+type measurementAllowlist map[string]bool
+
+// newMeasurementAllowlist returns an allowlist of measurements, or nil if there are none
+func newMeasurementAllowlist(measurements []string) measurementAllowlist {
+	if len(measurements) == 0 {
+		return nil
+	}
+	a := make(measurementAllowlist, len(measurements))
+	for _, m := range measurements {
+		a[m] = true
+	}
+	return a
+}
+
+// allows reports if measurement, or base, the measurement it is derived from, is written
+func (a measurementAllowlist) allows(measurement, base string) bool {
+	return a == nil || a[measurement] || a[base]
+}
+
+// measurementTemplate names measurements with a go template
+type measurementTemplate struct {
+	template *template.Template
+	rooms    map[string]string
+	warned   sync.Once
+}
+
+// measurementTemplateData is available to the measurement template
+type measurementTemplateData struct {
+	Type string
+	Name string
+	ID   string
+	// Room is the room of the sensor from the configured rooms, empty if it has none
+	Room string
+	// Measurement is the name used without a template
+	Measurement string
+}
+
+// newMeasurementTemplate parses text, it returns nil if text is empty
+func newMeasurementTemplate(text string, rooms map[string]string) (*measurementTemplate, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New("measurement").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &measurementTemplate{template: t, rooms: rooms}, nil
+}
+
+// name executes the template for sensor, falling back to measurement when it
+// fails or produces an empty name, which is logged only the first time
+func (m *measurementTemplate) name(sensor *deconz.Sensor, id, measurement string) string {
+	var b strings.Builder
+	err := m.template.Execute(&b, measurementTemplateData{
+		Type:        sensor.Type,
+		Name:        sensor.Name,
+		ID:          id,
+		Room:        m.rooms[sensor.Name],
+		Measurement: measurement,
+	})
+	if err == nil && b.Len() == 0 {
+		err = fmt.Errorf("empty measurement name for %s", sensor.Name)
+	}
+	if err != nil {
+		m.warned.Do(func() {
+			logging.Warnf("unable to execute measurement template, falling back to %s: %s", measurement, err)
+		})
+		return measurement
+	}
+	return b.String()
+}
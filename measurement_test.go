@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dfuchslin/deflux/deconz"
+)
+
+func TestMeasurementName(t *testing.T) {
+	for _, sensorType := range []string{"ZHATemperature", "ZHATemperature", "Daylight"} {
+		if name := measurementName(sensorType); name != fmt.Sprintf("deflux_%s", sensorType) {
+			t.Errorf("unexpected measurement name for %s: %s", sensorType, name)
+		}
+	}
+}
+
+func TestMeasurementFor(t *testing.T) {
+	if name := measurementFor("ZHATemperature", false); name != "deflux_ZHATemperature" {
+		t.Errorf("unexpected per type measurement: %s", name)
+	}
+	if name := measurementFor("ZHATemperature", true); name != "deflux" {
+		t.Errorf("unexpected single measurement: %s", name)
+	}
+}
+
+func TestSingleFieldName(t *testing.T) {
+	for configured, expected := range map[string]string{
+		"":        "value",
+		"reading": "reading",
+		"{field}": "temperature",
+	} {
+		if name := singleFieldName(configured, "temperature"); name != expected {
+			t.Errorf("expected %s for %q, got %s", expected, configured, name)
+		}
+	}
+}
+
+func BenchmarkMeasurementNameSprintf(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = fmt.Sprintf("deflux_%s", "ZHATemperature")
+	}
+}
+
+func BenchmarkMeasurementNameCached(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = measurementName("ZHATemperature")
+	}
+}
+
+func TestMeasurementAllowlist(t *testing.T) {
+	if !newMeasurementAllowlist(nil).allows("deflux_ZHATemperature", "deflux_ZHATemperature") {
+		t.Error("expected every measurement to be allowed without an allowlist")
+	}
+
+	a := newMeasurementAllowlist([]string{"deflux_ZHATemperature", "deflux_ZHAPower_power"})
+	for _, c := range []struct {
+		measurement, base string
+		allowed           bool
+	}{
+		{"deflux_ZHATemperature", "deflux_ZHATemperature", true},
+		{"deflux_ZHATemperature_temperature", "deflux_ZHATemperature", true},
+		{"deflux_ZHAPower_power", "deflux_ZHAPower", true},
+		{"deflux_ZHAPower_current", "deflux_ZHAPower", false},
+		{"deflux_ZHAHumidity", "deflux_ZHAHumidity", false},
+	} {
+		if a.allows(c.measurement, c.base) != c.allowed {
+			t.Errorf("expected %s to be allowed %t", c.measurement, c.allowed)
+		}
+	}
+}
+
+func TestMeasurementTemplate(t *testing.T) {
+	sensor := &deconz.Sensor{Name: "Kitchen sensor", Type: "ZHATemperature"}
+	rooms := map[string]string{"Kitchen sensor": "kitchen"}
+
+	for text, expected := range map[string]string{
+		"deflux_{{.Room}}_{{.Type}}": "deflux_kitchen_ZHATemperature",
+		"{{.Measurement}}_{{.ID}}":   "deflux_ZHATemperature_3",
+		"{{.Missing}}":               "deflux_ZHATemperature",
+		"{{if .Room}}{{end}}":        "deflux_ZHATemperature",
+	} {
+		m, err := newMeasurementTemplate(text, rooms)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		if name := m.name(sensor, "3", "deflux_ZHATemperature"); name != expected {
+			t.Errorf("%s: expected %s, got %s", text, expected, name)
+		}
+	}
+
+	if _, err := newMeasurementTemplate("{{.Type", nil); err == nil {
+		t.Error("expected an invalid template to be rejected")
+	}
+	if m, err := newMeasurementTemplate("", nil); m != nil || err != nil {
+		t.Errorf("expected no template, got %v, %v", m, err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsConfig configures the prometheus metrics endpoint
+type metricsConfig struct {
+	// Addr is the address to serve /metrics on, empty disables the endpoint
+	Addr string
+}
+
+var (
+	batchPoints = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "deflux_influx_batch_points",
+		Help:    "Number of points in each batch written to influxdb.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 13),
+	})
+	batchFill = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "deflux_influx_batch_fill_ratio",
+		Help: "How full the last batch written to influxdb was relative to BatchSize.",
+	})
+	batchFlushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "deflux_influx_batch_flushes_total",
+		Help: "Number of batches written to influxdb by what triggered the flush, size or interval.",
+	}, []string{"trigger"})
+	flushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "deflux_influx_flush_duration_seconds",
+		Help:    "Time taken to write a batch to influxdb.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+var processPanics = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "deflux_event_panics_total",
+	Help: "Number of events dropped as processing them panicked.",
+})
+
+var (
+	connectionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "deflux_deconz_connection_duration_seconds",
+		Help:    "How long past connections to deCONZ lasted before being lost.",
+		Buckets: prometheus.ExponentialBuckets(60, 4, 8),
+	})
+	reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "deflux_deconz_reconnects_total",
+		Help: "Number of times the connection to deCONZ has been reestablished.",
+	})
+	connection = &connectionMetrics{}
+	_          = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "deflux_deconz_connection_uptime_seconds",
+		Help: "How long the current connection to deCONZ has been up, zero while disconnected.",
+	}, connection.uptime)
+)
+
+// observeReader exposes the metrics of a sensor event reader, it may be called
+// for any number of readers
+func observeReader(r *deconz.SensorEventReader) {
+	r.Observer = connection
+	readers.add(r)
+}
+
+var (
+	parseErrorsDesc = prometheus.NewDesc("deflux_deconz_parse_errors_total",
+		"Number of events from deCONZ dropped as they could not be parsed.", nil, nil)
+	droppedEventsDesc = prometheus.NewDesc("deflux_deconz_dropped_events_total",
+		"Number of events from deCONZ dropped as the event buffer was full.", []string{"policy"}, nil)
+)
+
+// readers are the sensor event readers observed, registered once so
+// observing another reader does not register the metrics again
+var readers = &readerCollector{}
+
+func init() {
+	prometheus.MustRegister(readers)
+}
+
+// readerCollector collects the counters of sensor event readers, summed by
+// the policy of their event buffer
+type readerCollector struct {
+	mu      sync.Mutex
+	readers []*deconz.SensorEventReader
+}
+
+func (c *readerCollector) add(r *deconz.SensorEventReader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readers = append(c.readers, r)
+}
+
+// Describe implements prometheus.Collector
+func (c *readerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- parseErrorsDesc
+	ch <- droppedEventsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *readerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.readers) == 0 {
+		return
+	}
+
+	var parseErrors uint64
+	dropped := make(map[string]uint64)
+	for _, r := range c.readers {
+		parseErrors += r.ParseErrors()
+		dropped[r.FullPolicy()] += r.DroppedEvents()
+	}
+	ch <- prometheus.MustNewConstMetric(parseErrorsDesc, prometheus.CounterValue, float64(parseErrors))
+	for policy, n := range dropped {
+		ch <- prometheus.MustNewConstMetric(droppedEventsDesc, prometheus.CounterValue, float64(n), policy)
+	}
+}
+
+// connectionMetrics observes the connection to deCONZ
+type connectionMetrics struct {
+	mu          sync.Mutex
+	connectedAt time.Time
+	connections int
+}
+
+// Connected implements deconz.ConnectionObserver
+func (m *connectionMetrics) Connected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connections++
+	if m.connections > 1 {
+		reconnects.Inc()
+	}
+	m.connectedAt = time.Now()
+}
+
+// Disconnected implements deconz.ConnectionObserver
+func (m *connectionMetrics) Disconnected(uptime time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	connectionDuration.Observe(uptime.Seconds())
+	m.connectedAt = time.Time{}
+}
+
+// since returns when the current connection was established, zero while disconnected
+func (m *connectionMetrics) since() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connectedAt
+}
+
+func (m *connectionMetrics) uptime() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.connectedAt.IsZero() {
+		return 0
+	}
+	return time.Since(m.connectedAt).Seconds()
+}
+
+// serveMetrics starts serving prometheus metrics on addr in its own goroutine
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		logging.Infof("Serving metrics on %s", addr)
+		err := http.ListenAndServe(addr, mux)
+		logging.Errorf("metrics endpoint stopped: %s", err)
+	}()
+}
+
+// batchTransport observes the batches the influxdb client writes
+type batchTransport struct {
+	http.RoundTripper
+	batchSize uint
+}
+
+// RoundTrip records size and latency of write requests before passing them on
+func (t *batchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || !strings.HasSuffix(req.URL.Path, "/write") {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	// the body is a line protocol batch with a point on each line
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	points := bytes.Count(body, []byte{'\n'})
+
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	flushDuration.Observe(time.Since(start).Seconds())
+
+	batchPoints.Observe(float64(points))
+	if t.batchSize > 0 {
+		batchFill.Set(float64(points) / float64(t.batchSize))
+	}
+	if uint(points) >= t.batchSize {
+		batchFlushes.WithLabelValues("size").Inc()
+	} else {
+		batchFlushes.WithLabelValues("interval").Inc()
+	}
+
+	switch {
+	case err != nil:
+		status.write(err, time.Now())
+	case resp.StatusCode >= 300:
+		status.write(fmt.Errorf("influxdb returned %s", resp.Status), time.Now())
+	default:
+		atomic.AddInt64(&pendingPoints, -int64(points))
+		status.write(nil, time.Now())
+	}
+
+	return resp, err
+}
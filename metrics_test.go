@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBatchTransport(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := http.Client{Transport: &batchTransport{RoundTripper: http.DefaultTransport, batchSize: 4}}
+	batch := "deflux_ZHATemperature temperature=20.1\ndeflux_ZHATemperature temperature=20.2\n"
+
+	sizeBefore := testutil.ToFloat64(batchFlushes.WithLabelValues("size"))
+	intervalBefore := testutil.ToFloat64(batchFlushes.WithLabelValues("interval"))
+
+	resp, err := client.Post(server.URL+"/api/v2/write", "text/plain", strings.NewReader(batch))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	if received != batch {
+		t.Errorf("batch was not passed on unchanged: %q", received)
+	}
+	if fill := testutil.ToFloat64(batchFill); fill != 0.5 {
+		t.Errorf("expected fill ratio 0.5, got %f", fill)
+	}
+	if testutil.ToFloat64(batchFlushes.WithLabelValues("interval")) != intervalBefore+1 {
+		t.Error("expected an interval triggered flush")
+	}
+	if testutil.ToFloat64(batchFlushes.WithLabelValues("size")) != sizeBefore {
+		t.Error("expected no size triggered flush")
+	}
+}
+
+func TestObserveReader(t *testing.T) {
+	// observing a second reader must not register the metrics again
+	for i := 0; i < 2; i++ {
+		observeReader(&deconz.SensorEventReader{})
+	}
+	if n := testutil.CollectAndCount(readers); n != 2 {
+		t.Errorf("expected the parse errors and dropped events, got %d metrics", n)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// orgTimeout bounds looking up the organizations of influxdb
+const orgTimeout = 10 * time.Second
+
+// detectOrg returns the name of the only organization the token of client
+// can access, it fails if there are none or several
+func detectOrg(client influxdb2.Client) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), orgTimeout)
+	defer cancel()
+
+	orgs, err := client.OrganizationsAPI().GetOrganizations(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to list organizations: %s", err)
+	}
+	if orgs == nil || len(*orgs) == 0 {
+		return "", fmt.Errorf("the token can access no organization")
+	}
+	if len(*orgs) > 1 {
+		names := make([]string, 0, len(*orgs))
+		for _, o := range *orgs {
+			names = append(names, o.Name)
+		}
+		return "", fmt.Errorf("the token can access several organizations, configure one of %s as org", strings.Join(names, ", "))
+	}
+	return (*orgs)[0].Name, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+func TestDetectOrg(t *testing.T) {
+	for _, c := range []struct {
+		response string
+		org      string
+		valid    bool
+	}{
+		{`{"orgs":[{"id":"0000000000000001","name":"home"}]}`, "home", true},
+		{`{"orgs":[{"id":"0000000000000001","name":"home"},{"id":"0000000000000002","name":"work"}]}`, "", false},
+		{`{"orgs":[]}`, "", false},
+	} {
+		influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v2/orgs" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(c.response))
+		}))
+		client := influxdb2.NewClient(influx.URL, "token")
+
+		org, err := detectOrg(client)
+		if (err == nil) != c.valid || org != c.org {
+			t.Errorf("%s: expected org %q and valid %t, got %q and %v", c.response, c.org, c.valid, org, err)
+		}
+		client.Close()
+		influx.Close()
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// precisions maps measurements to the precision of their timestamps
+type precisions map[string]time.Duration
+
+// validPrecision reports if precision is one influxdb can write timestamps with
+func validPrecision(precision time.Duration) bool {
+	switch precision {
+	case time.Nanosecond, time.Microsecond, time.Millisecond, time.Second:
+		return true
+	}
+	return false
+}
+
+// validate returns an error if a precision is not positive
+func (p precisions) validate() error {
+	for measurement, precision := range p {
+		if precision <= 0 {
+			return fmt.Errorf("precision of %s must be positive, got %s", measurement, precision)
+		}
+	}
+	return nil
+}
+
+// truncate returns ts truncated to the precision of measurement, or of base,
+// the measurement it is derived from, if measurement has none
+func (p precisions) truncate(measurement, base string, ts time.Time) time.Time {
+	precision, ok := p[measurement]
+	if !ok {
+		precision, ok = p[base]
+	}
+	if !ok {
+		return ts
+	}
+	return ts.Truncate(precision)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrecisionsTruncate(t *testing.T) {
+	p := precisions{
+		"deflux_ZHAPower":       time.Second,
+		"deflux_ZHAPower_power": time.Minute,
+	}
+	ts := time.Date(2021, 3, 1, 12, 30, 15, 123456789, time.UTC)
+
+	for _, c := range []struct {
+		measurement, base string
+		expected          time.Time
+	}{
+		{"deflux_ZHAPower", "deflux_ZHAPower", time.Date(2021, 3, 1, 12, 30, 15, 0, time.UTC)},
+		{"deflux_ZHAPower_current", "deflux_ZHAPower", time.Date(2021, 3, 1, 12, 30, 15, 0, time.UTC)},
+		{"deflux_ZHAPower_power", "deflux_ZHAPower", time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{"deflux_ZHAPresence", "deflux_ZHAPresence", ts},
+	} {
+		if truncated := p.truncate(c.measurement, c.base, ts); !truncated.Equal(c.expected) {
+			t.Errorf("unexpected timestamp of %s: %s", c.measurement, truncated)
+		}
+	}
+}
+
+func TestPrecisionsValidate(t *testing.T) {
+	if err := (precisions{"deflux_ZHAPower": time.Second}).validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := (precisions{"deflux_ZHAPower": 0}).validate(); err == nil {
+		t.Error("expected an error on a zero precision")
+	}
+}
+
+func TestValidPrecision(t *testing.T) {
+	for precision, valid := range map[time.Duration]bool{
+		time.Nanosecond:  true,
+		time.Millisecond: true,
+		time.Second:      true,
+		time.Minute:      false,
+		0:                false,
+	} {
+		if validPrecision(precision) != valid {
+			t.Errorf("unexpected validity of %s", precision)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// defaultQuarantineMeasurement is the measurement quarantined frames are written to unless configured
+const defaultQuarantineMeasurement = "deflux_quarantine"
+
+// maxQuarantinePayload is the largest string field influxdb accepts, larger frames are not quarantined
+const maxQuarantinePayload = 64 * 1024
+
+// quarantineConfig writes the frames from deconz that could not be mapped,
+// e.g. of unknown sensor types, to a separate bucket or measurement
+type quarantineConfig struct {
+	Enabled bool
+	// Bucket defaults to the bucket of influxdb2
+	Bucket string
+	// Measurement defaults to defaultQuarantineMeasurement
+	Measurement string
+	// BatchSize defaults to the batch size of influxdb2
+	BatchSize uint
+}
+
+// quarantineInflux returns the influxdb2 configuration the quarantine is written with
+func (c influxdb2ConfigProxy) quarantineInflux() influxdb2ConfigProxy {
+	if c.Quarantine.BatchSize > 0 {
+		c.BatchSize = c.Quarantine.BatchSize
+	}
+	return c
+}
+
+// conversionError is the reason of quarantining an event that was parsed but
+// could not be converted to a point
+type conversionError struct {
+	err error
+}
+
+func (e conversionError) Error() string {
+	return e.err.Error()
+}
+
+// quarantine writes frames that could not be parsed or converted to influxdb
+// along with the reason
+type quarantine struct {
+	sink        Sink
+	measurement string
+}
+
+// newQuarantine returns a quarantine writing with client as configured by c
+func newQuarantine(client influxdb2.Client, org, bucket string, c quarantineConfig) *quarantine {
+	if c.Bucket != "" {
+		bucket = c.Bucket
+	}
+	measurement := c.Measurement
+	if measurement == "" {
+		measurement = defaultQuarantineMeasurement
+	}
+	return &quarantine{sink: newInfluxSink(client, org, bucket), measurement: measurement}
+}
+
+// Quarantine implements deconz.Quarantiner, the raw frame is written as the
+// field _raw tagged with the reason it could not be mapped
+func (q *quarantine) Quarantine(payload []byte, err error) {
+	if len(payload) > maxQuarantinePayload {
+		logging.Warnf("not quarantining frame of %d bytes, it exceeds the %d bytes allowed in a field", len(payload), maxQuarantinePayload)
+		return
+	}
+
+	tags := map[string]string{"reason": "parse_error"}
+	var terr event.UnknownTypeError
+	var cerr conversionError
+	if errors.As(err, &terr) {
+		tags["reason"] = "unknown_type"
+		tags["type"] = terr.Type
+	} else if errors.As(err, &cerr) {
+		tags["reason"] = "conversion_error"
+	}
+
+	werr := q.sink.Write(influxdb2.NewPoint(q.measurement, tags,
+		map[string]interface{}{"_raw": string(payload), "error": err.Error()},
+		time.Now(),
+	))
+	if werr != nil {
+		logging.Errorf("unable to quarantine frame: %s", werr)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestQuarantine(t *testing.T) {
+	sink := &testSink{}
+	q := &quarantine{sink: sink, measurement: defaultQuarantineMeasurement}
+
+	q.Quarantine([]byte(`{"id":"12"}`), event.UnknownTypeError{Type: "ZHAUnmapped"})
+	q.Quarantine([]byte(`{garbage`), errors.New("invalid character"))
+	q.Quarantine(make([]byte, maxQuarantinePayload+1), errors.New("too large"))
+
+	if len(sink.points) != 2 {
+		t.Fatalf("expected two points, got %d", len(sink.points))
+	}
+	for i, expected := range []string{
+		`deflux_quarantine,reason=unknown_type,type=ZHAUnmapped _raw="{\"id\":\"12\"}",error=`,
+		`deflux_quarantine,reason=parse_error _raw="{garbage",error="invalid character" `,
+	} {
+		line := write.PointToLineProtocol(sink.points[i], time.Nanosecond)
+		if !strings.HasPrefix(line, expected) {
+			t.Errorf("expected line protocol starting with %q, got %q", expected, line)
+		}
+	}
+}
+
+func TestQuarantineBatchSize(t *testing.T) {
+	enabled := true
+	c := influxdb2ConfigProxy{Enabled: &enabled, BatchSize: 20, Quarantine: quarantineConfig{Enabled: true, BatchSize: 500}}
+
+	if size := c.options(nil).BatchSize(); size != 20 {
+		t.Errorf("expected the sink of influxdb2 to batch 20 points, got %d", size)
+	}
+	if size := c.quarantineInflux().options(nil).BatchSize(); size != 500 {
+		t.Errorf("expected the quarantine to batch 500 points, got %d", size)
+	}
+	c.Quarantine.BatchSize = 0
+	if size := c.quarantineInflux().options(nil).BatchSize(); size != 20 {
+		t.Errorf("expected the quarantine to default to the batch size of influxdb2, got %d", size)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteConfig configures writing to a prometheus remote write endpoint
+type remoteWriteConfig struct {
+	// URL of the endpoint, e.g. http://prometheus:9090/api/v1/write, empty
+	// disables remote write
+	URL string
+	// Username and Password are sent as basic auth if Username is set
+	Username string
+	Password string
+	// BearerToken is sent in the Authorization header if set
+	BearerToken string
+	// Headers are sent with every request, e.g. X-Scope-OrgID of Mimir
+	Headers map[string]string
+	// BatchSize is the number of samples sent at once, defaults to 500
+	BatchSize int
+	// FlushInterval sends the buffered samples at least this often, defaults to 10s
+	FlushInterval time.Duration
+	// Timeout bounds every request, defaults to 30s
+	Timeout time.Duration
+	// MaxPending bounds the samples kept to retry while the endpoint fails,
+	// the oldest are dropped beyond it, defaults to 10000
+	MaxPending int
+}
+
+// defaults of remoteWriteConfig
+const (
+	defaultRemoteWriteBatchSize     = 500
+	defaultRemoteWriteFlushInterval = 10 * time.Second
+	defaultRemoteWriteTimeout       = 30 * time.Second
+	defaultRemoteWriteMaxPending    = 10000
+)
+
+var remoteWriteSamples = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "deflux_remote_write_samples_total",
+	Help: "Number of samples given to the remote write endpoint, by result sent, retried or dropped.",
+}, []string{"result"})
+
+// validate returns an error if c can not be used to write
+func (c remoteWriteConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url must be http or https, got %q", c.URL)
+	}
+	if c.Username != "" && c.BearerToken != "" {
+		return fmt.Errorf("configure either username and password or a bearer token")
+	}
+	err = validateHeaders(c.Headers)
+	if err != nil {
+		return fmt.Errorf("invalid headers: %s", err)
+	}
+	return nil
+}
+
+// remoteSample is a sample of the time series identified by its labels
+type remoteSample struct {
+	labels    []remoteLabel
+	value     float64
+	timestamp int64
+}
+
+type remoteLabel struct {
+	name, value string
+}
+
+// remoteWriteError is a failed request, retry tells if sending the same
+// samples again may succeed
+type remoteWriteError struct {
+	err   error
+	retry bool
+}
+
+func (e *remoteWriteError) Error() string {
+	return e.err.Error()
+}
+
+// remoteWriteSink converts points to samples and sends them in batches to a
+// prometheus remote write endpoint, it is safe for concurrent use. Samples of
+// failed requests are sent again with the next batch, unless the endpoint
+// rejected them
+type remoteWriteSink struct {
+	config remoteWriteConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	samples []remoteSample
+	// retryAt holds back batches filled by Write after a failure, so a
+	// failing endpoint is retried every FlushInterval instead of every point
+	retryAt time.Time
+	// sending serializes requests so samples of a series arrive in order
+	sending sync.Mutex
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// newRemoteWriteSink returns a sink writing as configured by c, its buffered
+// samples are sent every FlushInterval until it is closed
+func newRemoteWriteSink(c remoteWriteConfig) *remoteWriteSink {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultRemoteWriteBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultRemoteWriteFlushInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultRemoteWriteTimeout
+	}
+	if c.MaxPending <= 0 {
+		c.MaxPending = defaultRemoteWriteMaxPending
+	}
+	if c.MaxPending < c.BatchSize {
+		c.MaxPending = c.BatchSize
+	}
+
+	s := &remoteWriteSink{
+		config: c,
+		client: &http.Client{Timeout: c.Timeout, Transport: &logging.TraceTransport{RoundTripper: http.DefaultTransport}},
+		stop:   make(chan struct{}),
+	}
+	s.stopped.Add(1)
+	go s.flushPeriodically()
+	return s
+}
+
+func (s *remoteWriteSink) flushPeriodically() {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Flush(context.Background())
+			if err != nil {
+				logging.Errorf("unable to send samples to %s: %s", s.config.URL, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Write implements Sink, p is sent along with the next batch, failures are
+// logged rather than returned as the batch holds the samples of other points
+func (s *remoteWriteSink) Write(p *write.Point) error {
+	samples := pointSamples(p)
+	if len(samples) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.samples = append(s.samples, samples...)
+	s.dropOverflow()
+	full := len(s.samples) >= s.config.BatchSize && !time.Now().Before(s.retryAt)
+	s.mu.Unlock()
+
+	if full {
+		err := s.send(context.Background(), s.take())
+		if err != nil {
+			logging.Errorf("unable to send samples to %s: %s", s.config.URL, err)
+		}
+	}
+	return nil
+}
+
+// take removes up to BatchSize of the oldest buffered samples
+func (s *remoteWriteSink) take() []remoteSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.samples)
+	if n > s.config.BatchSize {
+		n = s.config.BatchSize
+	}
+	samples := append([]remoteSample(nil), s.samples[:n]...)
+	s.samples = s.samples[n:]
+	return samples
+}
+
+// requeue puts the samples of a failed request back in front of the buffer
+func (s *remoteWriteSink) requeue(samples []remoteSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(samples, s.samples...)
+	s.dropOverflow()
+	s.retryAt = time.Now().Add(s.config.FlushInterval)
+}
+
+// dropOverflow drops the oldest samples beyond MaxPending, s.mu must be held
+func (s *remoteWriteSink) dropOverflow() {
+	if over := len(s.samples) - s.config.MaxPending; over > 0 {
+		remoteWriteSamples.WithLabelValues("dropped").Add(float64(over))
+		s.samples = s.samples[over:]
+	}
+}
+
+// Flush implements Sink, the buffered samples are sent in batches until one fails
+func (s *remoteWriteSink) Flush(ctx context.Context) error {
+	for {
+		samples := s.take()
+		if len(samples) == 0 {
+			return nil
+		}
+		err := s.send(ctx, samples)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close implements Sink, the buffered samples are sent first
+func (s *remoteWriteSink) Close() error {
+	close(s.stop)
+	s.stopped.Wait()
+	return s.Flush(context.Background())
+}
+
+// send sends samples in a single request, they are requeued if it failed
+// and may succeed when retried, the error is left to the caller to log
+func (s *remoteWriteSink) send(ctx context.Context, samples []remoteSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	s.sending.Lock()
+	defer s.sending.Unlock()
+
+	err := s.post(ctx, snappy.Encode(nil, encodeWriteRequest(samples)))
+	if err == nil {
+		remoteWriteSamples.WithLabelValues("sent").Add(float64(len(samples)))
+		return nil
+	}
+	if rerr, ok := err.(*remoteWriteError); ok && !rerr.retry {
+		remoteWriteSamples.WithLabelValues("dropped").Add(float64(len(samples)))
+		return fmt.Errorf("dropping %d samples: %s", len(samples), err)
+	}
+	remoteWriteSamples.WithLabelValues("retried").Add(float64(len(samples)))
+	s.requeue(samples)
+	return fmt.Errorf("retrying %d samples: %s", len(samples), err)
+}
+
+func (s *remoteWriteSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "deflux/"+version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	if s.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.BearerToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		// the endpoint rejects samples it will never accept, such as out of
+		// order samples, with a client error
+		retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+		return &remoteWriteError{err: fmt.Errorf("remote write endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(message))), retry: retry}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// pointSamples converts the numeric and boolean fields of p to samples named
+// after the measurement and field, e.g. deflux_ZHATemperature_temperature,
+// labeled with the tags of p. String fields have no sample
+func pointSamples(p *write.Point) []remoteSample {
+	tags := make([]remoteLabel, 0, len(p.TagList())+1)
+	for _, t := range p.TagList() {
+		tags = append(tags, remoteLabel{name: metricName(t.Key), value: t.Value})
+	}
+
+	timestamp := p.Time().UnixNano() / int64(time.Millisecond)
+	samples := make([]remoteSample, 0, len(p.FieldList()))
+	for _, f := range p.FieldList() {
+		value, ok := sampleValue(f.Value)
+		if !ok {
+			continue
+		}
+		labels := append([]remoteLabel{{name: "__name__", value: metricName(p.Name() + "_" + f.Key)}}, tags...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		samples = append(samples, remoteSample{labels: labels, value: value, timestamp: timestamp})
+	}
+	return samples
+}
+
+// sampleValue returns v as the float64 value of a sample, booleans are 0 or 1
+func sampleValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// metricName replaces the characters prometheus does not allow in metric
+// and label names with _
+func metricName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// encodeWriteRequest encodes samples as the protobuf WriteRequest of the
+// remote write protocol, every sample is a time series of its own
+func encodeWriteRequest(samples []remoteSample) []byte {
+	var request []byte
+	for _, s := range samples {
+		var series []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes a WriteRequest to a series per line such as
+// name{label="value"} 1.5 1600000000000
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	var series []string
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		ts, m := protowire.ConsumeBytes(b[n:])
+		if m < 0 {
+			t.Fatalf("invalid time series: %s", protowire.ParseError(m))
+		}
+		b = b[n+m:]
+
+		var name string
+		var labels, samples []string
+		for len(ts) > 0 {
+			num, _, n := protowire.ConsumeTag(ts)
+			v, m := protowire.ConsumeBytes(ts[n:])
+			ts = ts[n+m:]
+			fields := map[protowire.Number]uint64{}
+			strs := map[protowire.Number]string{}
+			for len(v) > 0 {
+				fnum, typ, n := protowire.ConsumeTag(v)
+				switch typ {
+				case protowire.BytesType:
+					s, m := protowire.ConsumeString(v[n:])
+					strs[fnum] = s
+					v = v[n+m:]
+				case protowire.Fixed64Type:
+					x, m := protowire.ConsumeFixed64(v[n:])
+					fields[fnum] = x
+					v = v[n+m:]
+				case protowire.VarintType:
+					x, m := protowire.ConsumeVarint(v[n:])
+					fields[fnum] = x
+					v = v[n+m:]
+				default:
+					t.Fatalf("unexpected wire type %d", typ)
+				}
+			}
+			switch num {
+			case 1:
+				if strs[1] == "__name__" {
+					name = strs[2]
+				} else {
+					labels = append(labels, fmt.Sprintf("%s=%q", strs[1], strs[2]))
+				}
+			case 2:
+				samples = append(samples, fmt.Sprintf("%g %d", math.Float64frombits(fields[1]), fields[2]))
+			}
+		}
+		series = append(series, fmt.Sprintf("%s{%s} %s", name, strings.Join(labels, ","), strings.Join(samples, ";")))
+	}
+	return series
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	var requests [][]string
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("unable to decode body: %s", err)
+		}
+		requests = append(requests, decodeWriteRequest(t, body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(remoteWriteConfig{
+		URL:           server.URL,
+		BearerToken:   "secret",
+		Headers:       map[string]string{"X-Scope-OrgID": "home"},
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+	})
+
+	ts := time.Unix(1600000000, 0)
+	err := sink.Write(influxdb2.NewPoint("deflux_ZHATemperature",
+		map[string]string{"name": "Kitchen", "sensor-id": "1"},
+		map[string]interface{}{"temperature": 21.5, "label": "ignored"}, ts))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no request before the batch is full, got %d", len(requests))
+	}
+
+	err = sink.Write(influxdb2.NewPoint("deflux_ZHAOpenClose",
+		map[string]string{"name": "Door"},
+		map[string]interface{}{"open": true, "battery": 90}, ts))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected a request once the batch is full, got %d", len(requests))
+	}
+
+	expected := []string{
+		`deflux_ZHATemperature_temperature{name="Kitchen",sensor_id="1"} 21.5 1600000000000`,
+		`deflux_ZHAOpenClose_battery{name="Door"} 90 1600000000000`,
+		`deflux_ZHAOpenClose_open{name="Door"} 1 1600000000000`,
+	}
+	if !reflect.DeepEqual(requests[0], expected) {
+		t.Errorf("expected series\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(requests[0], "\n"))
+	}
+
+	for name, value := range map[string]string{
+		"Authorization":                     "Bearer secret",
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-Orgid":                     "home",
+	} {
+		if header.Get(name) != value {
+			t.Errorf("expected header %s %q, got %q", name, value, header.Get(name))
+		}
+	}
+
+	// the remaining samples are sent when closing
+	err = sink.Write(influxdb2.NewPoint("deflux_ZHAHumidity", nil, map[string]interface{}{"humidity": 40.0}, ts))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	err = sink.Close()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if len(requests) != 2 || len(requests[1]) != 1 {
+		t.Errorf("expected the humidity to be sent when closing, got %v", requests)
+	}
+}
+
+func TestRemoteWriteSinkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(remoteWriteConfig{URL: server.URL, Username: "user", Password: "pass", FlushInterval: time.Hour})
+	defer sink.Close()
+
+	err := sink.Write(influxdb2.NewPoint("m", nil, map[string]interface{}{"value": 1.0}, time.Now()))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	err = sink.Flush(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("expected the error of the endpoint, got %v", err)
+	}
+}
+
+func TestRemoteWriteConfigValidate(t *testing.T) {
+	for _, c := range []struct {
+		config remoteWriteConfig
+		valid  bool
+	}{
+		{remoteWriteConfig{URL: "http://prometheus:9090/api/v1/write"}, true},
+		{remoteWriteConfig{URL: "https://mimir/api/v1/push", Username: "user", Password: "pass"}, true},
+		{remoteWriteConfig{URL: "prometheus:9090"}, false},
+		{remoteWriteConfig{URL: "http://prometheus", Username: "user", BearerToken: "token"}, false},
+		{remoteWriteConfig{URL: "http://prometheus", Headers: map[string]string{"bad header": "x"}}, false},
+	} {
+		err := c.config.validate()
+		if (err == nil) != c.valid {
+			t.Errorf("%+v: expected valid %t, got %v", c.config, c.valid, err)
+		}
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"deflux_ZHATemperature_temperature": "deflux_ZHATemperature_temperature",
+		"sensor-id":                         "sensor_id",
+		"1st floor":                         "_st_floor",
+		"co2.ppm":                           "co2_ppm",
+	} {
+		if got := metricName(name); got != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, got)
+		}
+	}
+}
+
+func TestRemoteWriteSinkRetry(t *testing.T) {
+	failures := 1
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, _ := snappy.Decode(nil, compressed)
+		received = append(received, decodeWriteRequest(t, body)...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(remoteWriteConfig{URL: server.URL, BatchSize: 1, MaxPending: 2, FlushInterval: time.Hour})
+	defer sink.Close()
+
+	// the failure is logged by the sink, not returned by Write
+	retried := testutil.ToFloat64(remoteWriteSamples.WithLabelValues("retried"))
+	ts := time.Unix(1600000000, 0)
+	err := sink.Write(influxdb2.NewPoint("m", nil, map[string]interface{}{"value": 1.0}, ts))
+	if err != nil {
+		t.Errorf("expected the failure to be handled by the sink, got %s", err)
+	}
+	if n := testutil.ToFloat64(remoteWriteSamples.WithLabelValues("retried")) - retried; n != 1 {
+		t.Errorf("expected 1 sample to be retried, got %g", n)
+	}
+
+	// later batches wait for the retry, beyond MaxPending the oldest are dropped
+	dropped := testutil.ToFloat64(remoteWriteSamples.WithLabelValues("dropped"))
+	sink.Write(influxdb2.NewPoint("m", nil, map[string]interface{}{"value": 2.0}, ts))
+	sink.Write(influxdb2.NewPoint("m", nil, map[string]interface{}{"value": 3.0}, ts))
+	if len(received) != 0 {
+		t.Errorf("expected no batch to be sent before the retry, got %v", received)
+	}
+	if n := testutil.ToFloat64(remoteWriteSamples.WithLabelValues("dropped")) - dropped; n != 1 {
+		t.Errorf("expected 1 sample to be dropped, got %g", n)
+	}
+
+	err = sink.Flush(context.Background())
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	expected := []string{"m_value{} 2 1600000000000", "m_value{} 3 1600000000000"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected %v to be sent, got %v", expected, received)
+	}
+}
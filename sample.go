@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/deconz/event"
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// sampleCommand collects an example frame per sensor type seen, including
+// types without a mapping, and writes them to a file once --duration passed
+func sampleCommand(args []string) error {
+	flags := flag.NewFlagSet("sample", flag.ContinueOnError)
+	duration := flags.Duration("duration", 10*time.Minute, "how long to collect frames")
+	output := flags.String("output", "deflux-samples.json", "file to write the frames to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadFlagConfiguration()
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	s := newSampler()
+	sensorChan, reader, err := sensorEventChan(config.Deconz, s)
+	if err != nil {
+		return fmt.Errorf("could not connect to deconz: %s", err)
+	}
+
+	logging.Infof("Collecting frames for %s, interrupt to stop early", *duration)
+	timeout := time.After(*duration)
+collect:
+	for {
+		select {
+		case e := <-sensorChan:
+			s.add(e)
+		case <-timeout:
+			break collect
+		case <-signals:
+			break collect
+		}
+	}
+	reader.StopReadEvents()
+
+	data, err := s.marshal()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(*output, data, 0644)
+	if err != nil {
+		return err
+	}
+	logging.Infof("Wrote frames of %d sensor types to %s", s.len(), *output)
+	return nil
+}
+
+// sampler keeps the first frame of every sensor type, frames with a state
+// replace ones holding only a config
+type sampler struct {
+	mu       sync.Mutex
+	frames   map[string]json.RawMessage
+	hasState map[string]bool
+}
+
+func newSampler() *sampler {
+	return &sampler{frames: make(map[string]json.RawMessage), hasState: make(map[string]bool)}
+}
+
+// sampleFrame is the websocket frame of an event
+type sampleFrame struct {
+	Type     string          `json:"t"`
+	Event    string          `json:"e"`
+	Resource string          `json:"r"`
+	ID       string          `json:"id"`
+	State    json.RawMessage `json:"state,omitempty"`
+	Config   json.RawMessage `json:"config,omitempty"`
+}
+
+// eventFrame returns the websocket frame of e
+func eventFrame(e *deconz.SensorEvent) ([]byte, error) {
+	return json.Marshal(sampleFrame{
+		Type:     e.Event.Type,
+		Event:    e.Event.Event,
+		Resource: e.Event.Resource,
+		ID:       fmt.Sprint(e.Event.ID),
+		State:    e.Event.RawState,
+		Config:   e.Event.Config,
+	})
+}
+
+// add keeps the frame of e if it is the first of its type
+func (s *sampler) add(e *deconz.SensorEvent) {
+	frame, err := eventFrame(e)
+	if err != nil {
+		logging.Warnf("unable to sample event of %s: %s", e.Sensor.Name, err)
+		return
+	}
+	s.keep(e.Sensor.Type, frame, len(e.Event.RawState) > 0)
+}
+
+// Quarantine implements deconz.Quarantiner, keeping frames of unknown types
+func (s *sampler) Quarantine(payload []byte, err error) {
+	var terr event.UnknownTypeError
+	if !errors.As(err, &terr) {
+		return
+	}
+	s.keep(terr.Type, append(json.RawMessage(nil), payload...), true)
+}
+
+func (s *sampler) keep(sensorType string, frame json.RawMessage, hasState bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.frames[sensorType]; ok && (s.hasState[sensorType] || !hasState) {
+		return
+	}
+	if !json.Valid(frame) {
+		return
+	}
+	s.frames[sensorType] = frame
+	s.hasState[sensorType] = hasState
+}
+
+func (s *sampler) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.frames)
+}
+
+// marshal returns the frames keyed by sensor type
+func (s *sampler) marshal() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.MarshalIndent(s.frames, "", "  ")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/deconz/event"
+)
+
+func TestSampler(t *testing.T) {
+	d := event.Decoder{TypeStore: temperatureLookup{}}
+	configOnly, err := d.Parse([]byte(`{"e":"changed","id":"4","r":"sensors","config":{"battery":90},"t":"event"}`))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	withState, err := d.Parse([]byte(gatewayTemperatureEvent))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	sensor := &deconz.Sensor{Name: "Kitchen", Type: "ZHATemperature"}
+
+	s := newSampler()
+	s.add(&deconz.SensorEvent{Event: configOnly, Sensor: sensor})
+	s.add(&deconz.SensorEvent{Event: withState, Sensor: sensor})
+	s.add(&deconz.SensorEvent{Event: configOnly, Sensor: sensor})
+	s.Quarantine([]byte(`{"e":"changed","id":"9","r":"sensors","state":{"moisture":1},"t":"event"}`), event.UnknownTypeError{Type: "ZHASoil"})
+	s.Quarantine([]byte(`{garbage`), event.EventErrorImpl{})
+
+	data, err := s.marshal()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	var frames map[string]map[string]interface{}
+	err = json.Unmarshal(data, &frames)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	if len(frames) != 2 {
+		t.Errorf("expected frames of 2 types, got %s", data)
+	}
+	if frames["ZHATemperature"]["state"] == nil {
+		t.Errorf("expected the frame with a state to be kept, got %v", frames["ZHATemperature"])
+	}
+	if frames["ZHASoil"]["id"] != "9" {
+		t.Errorf("expected the frame of the unknown type, got %v", frames["ZHASoil"])
+	}
+
+	// the frames are ready to use as fixtures
+	raw, _ := json.Marshal(frames["ZHATemperature"])
+	if _, err := d.Parse(raw); err != nil {
+		t.Errorf("unable to parse sampled frame: %s", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+// sentinels lists the values sensors report instead of a reading, keys are a
+// field name or a sensor type and field name joined by a dot, e.g.
+// "ZHATemperature.temperature", which takes precedence over the plain field name
+type sentinels map[string][]float64
+
+// omit removes the numeric fields holding a sentinel value from fields
+func (s sentinels) omit(sensorType string, fields map[string]interface{}) {
+	if len(s) == 0 {
+		return
+	}
+
+	for k, v := range fields {
+		values, ok := s[sensorType+"."+k]
+		if !ok {
+			values, ok = s[k]
+		}
+		if !ok {
+			continue
+		}
+
+		value, _, ok := numericValue(v)
+		if !ok {
+			continue
+		}
+		for _, sentinel := range values {
+			if value == sentinel {
+				delete(fields, k)
+				break
+			}
+		}
+	}
+}
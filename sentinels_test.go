@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSentinelsOmit(t *testing.T) {
+	s := sentinels{
+		"temperature":          {-327.68},
+		"ZHAPressure.pressure": {0, -32768},
+	}
+
+	for _, c := range []struct {
+		sensorType string
+		fields     map[string]interface{}
+		expected   []string
+	}{
+		{"ZHATemperature", map[string]interface{}{"temperature": -327.68, "battery": 100}, []string{"battery"}},
+		{"ZHATemperature", map[string]interface{}{"temperature": 21.5}, []string{"temperature"}},
+		{"ZHAPressure", map[string]interface{}{"pressure": -32768}, []string{}},
+		{"ZHAPressure", map[string]interface{}{"pressure": 1013}, []string{"pressure"}},
+		{"ZHAOpenClose", map[string]interface{}{"pressure": 0}, []string{"pressure"}},
+	} {
+		s.omit(c.sensorType, c.fields)
+		if len(c.fields) != len(c.expected) {
+			t.Errorf("%s: expected fields %v, got %v", c.sensorType, c.expected, c.fields)
+		}
+		for _, field := range c.expected {
+			if _, found := c.fields[field]; !found {
+				t.Errorf("%s: expected field %s in %v", c.sensorType, field, c.fields)
+			}
+		}
+	}
+}
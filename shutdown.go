@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// defaultShutdownTimeout is used unless the configuration sets ShutdownTimeout
+const defaultShutdownTimeout = 10 * time.Second
+
+// pendingPoints counts the points handed to the influxdb clients which have
+// not been written yet, it is updated atomically
+var pendingPoints int64
+
+// shutdownTimeout returns the configured ShutdownTimeout or its default
+func (c *Configuration) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout > 0 {
+		return c.ShutdownTimeout
+	}
+	return defaultShutdownTimeout
+}
+
+// shutdown waits for the writers, then flushes and closes every sink, abandoning
+// the pending points if it takes longer than timeout, it reports if it finished in time
+func shutdown(timeout time.Duration, writers *sync.WaitGroup, sinks []Sink) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		writers.Wait()
+		for _, s := range sinks {
+			err := s.Flush(ctx)
+			if err != nil {
+				logging.Warnf("unable to flush %T: %s", s, err)
+			}
+			s.Close()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logging.Infof("Shutdown complete")
+		return true
+	case <-ctx.Done():
+		logging.Warnf("Shutdown timed out after %s, dropping %d points not yet written", timeout, atomic.LoadInt64(&pendingPoints))
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+func TestShutdownFlushes(t *testing.T) {
+	written := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		written <- true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := influxdb2ConfigProxy{BatchSize: 100}
+	client := influxdb2.NewClientWithOptions(server.URL, "token", c.options(nil))
+	client.WriteAPI("org", "bucket").WriteRecord("deflux_ZHATemperature temperature=20.1")
+
+	var wg sync.WaitGroup
+	if !shutdown(time.Second, &wg, []Sink{newInfluxSink(client, "org", "bucket")}) {
+		t.Error("expected shutdown to finish in time")
+	}
+	select {
+	case <-written:
+	default:
+		t.Error("expected the pending point to be flushed")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	defer wg.Done()
+
+	// a writer that never returns
+	if shutdown(10*time.Millisecond, &wg, nil) {
+		t.Error("expected shutdown to time out")
+	}
+}
+
+func TestShutdownClosesSinks(t *testing.T) {
+	sinks := []*testSink{{}, {}}
+
+	var wg sync.WaitGroup
+	if !shutdown(time.Second, &wg, []Sink{sinks[0], sinks[1]}) {
+		t.Error("expected shutdown to finish in time")
+	}
+	for i, s := range sinks {
+		if !s.flushed || !s.closed {
+			t.Errorf("expected sink %d to be flushed and closed, got %+v", i, s)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// lastActivity returns when the last event was received, or since if none
+// was received yet
+func (s *statusTracker) lastActivity(since time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastEvent.IsZero() {
+		return since
+	}
+	return s.lastEvent
+}
+
+// silentFor returns how long s received no events since, if it exceeds max
+func (s *statusTracker) silentFor(max time.Duration, since, now time.Time) (time.Duration, bool) {
+	silence := now.Sub(s.lastActivity(since))
+	return silence, silence > max
+}
+
+// minMaxSilence is the shortest maxsilence accepted, shorter ones are most
+// likely typos and would check for silence in a busy loop
+const minMaxSilence = time.Second
+
+// watchSilence exits deflux once no events were received for max, so a
+// supervisor restarts it, until stop is closed. The silence is measured from
+// the call at the earliest, so connecting to the gateway is not counted
+func watchSilence(s *statusTracker, max time.Duration, stop <-chan struct{}) {
+	since := time.Now()
+	// check often enough to exit shortly after max passed
+	ticker := time.NewTicker(max / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if silence, silent := s.silentFor(max, since, now); silent {
+				log.Fatalf("no events received for %s, exceeding maxsilence of %s, exiting", silence.Round(time.Second), max)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSilentFor(t *testing.T) {
+	started := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newStatusTracker(started.Add(-2 * time.Hour))
+
+	// the silence starts with watching it, not with deflux, so a long
+	// startupdelay is not counted
+	if silence, silent := s.silentFor(time.Hour, started, started.Add(30*time.Minute)); silent || silence != 30*time.Minute {
+		t.Errorf("expected 30m of silence within maxsilence, got %s (%t)", silence, silent)
+	}
+	if _, silent := s.silentFor(time.Hour, started, started.Add(61*time.Minute)); !silent {
+		t.Error("expected no events since starting to exceed maxsilence")
+	}
+
+	s.event("ZHATemperature", started.Add(50*time.Minute))
+	if silence, silent := s.silentFor(time.Hour, started, started.Add(61*time.Minute)); silent || silence != 11*time.Minute {
+		t.Errorf("expected 11m of silence since the last event, got %s (%t)", silence, silent)
+	}
+}
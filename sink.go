@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Sink is an output points are written to, it is flushed and closed when
+// shutting down
+type Sink interface {
+	// Write hands p to the sink, which may buffer it, the error is only
+	// returned by sinks writing synchronously
+	Write(p *write.Point) error
+	// Flush writes the buffered points, it gives up once ctx is done
+	Flush(ctx context.Context) error
+	// Close releases the sink, points buffered are written first
+	Close() error
+}
+
+// influxSink writes to influxdb with the write api of a client
+type influxSink struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPI
+}
+
+// newInfluxSink returns a sink writing to the bucket of org with client
+func newInfluxSink(client influxdb2.Client, org, bucket string) *influxSink {
+	return &influxSink{client: client, writeAPI: client.WriteAPI(org, bucket)}
+}
+
+// Write implements Sink, points are batched by the write api
+func (s *influxSink) Write(p *write.Point) error {
+	s.writeAPI.WritePoint(p)
+	atomic.AddInt64(&pendingPoints, 1)
+	return nil
+}
+
+// Flush implements Sink
+func (s *influxSink) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.writeAPI.Flush()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close implements Sink, closing the client flushes its write api
+func (s *influxSink) Close() error {
+	s.client.Close()
+	return nil
+}
+
+// blockingInfluxSink writes every point to influxdb before returning
+type blockingInfluxSink struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPIBlocking
+}
+
+// newBlockingInfluxSink returns a sink writing to the bucket of org with client synchronously
+func newBlockingInfluxSink(client influxdb2.Client, org, bucket string) *blockingInfluxSink {
+	return &blockingInfluxSink{client: client, writeAPI: client.WriteAPIBlocking(org, bucket)}
+}
+
+// Write implements Sink, it returns once influxdb confirmed the point
+func (s *blockingInfluxSink) Write(p *write.Point) error {
+	// the point is pending while it is written, a successful write is
+	// accounted for by the transport of the client
+	atomic.AddInt64(&pendingPoints, 1)
+	err := s.writeAPI.WritePoint(context.Background(), p)
+	if err != nil {
+		atomic.AddInt64(&pendingPoints, -1)
+	}
+	return err
+}
+
+// Flush implements Sink, there is nothing buffered
+func (s *blockingInfluxSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close implements Sink
+func (s *blockingInfluxSink) Close() error {
+	s.client.Close()
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+func TestBlockingInfluxSink(t *testing.T) {
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c := influxdb2ConfigProxy{BatchSize: 100}
+	client := influxdb2.NewClientWithOptions(server.URL, "token", c.options(nil))
+	sink := newBlockingInfluxSink(client, "org", "bucket")
+	defer sink.Close()
+
+	pending := atomic.LoadInt64(&pendingPoints)
+	p := influxdb2.NewPoint("deflux_ZHATemperature", map[string]string{"id": "5"}, map[string]interface{}{"temperature": 21.5}, time.Now())
+	if err := sink.Write(p); err != nil {
+		t.Errorf("unexpected error writing: %s", err)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sink.Write(p); err == nil {
+		t.Error("expected the failed write to be returned")
+	}
+
+	if atomic.LoadInt64(&pendingPoints) != pending {
+		t.Errorf("expected no points to be left pending, got %d", atomic.LoadInt64(&pendingPoints)-pending)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// waitStartupDelay waits delay before deflux first connects, it returns
+// false if a signal was received meanwhile
+func waitStartupDelay(delay time.Duration, signals <-chan os.Signal) bool {
+	if delay <= 0 {
+		return true
+	}
+
+	logging.Infof("Waiting %s before connecting", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case sig := <-signals:
+		logging.Infof("Received %s during the startup delay, exiting", sig)
+		return false
+	}
+}
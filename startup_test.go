@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitStartupDelay(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	if !waitStartupDelay(0, signals) {
+		t.Error("expected no delay to return right away")
+	}
+
+	started := time.Now()
+	if !waitStartupDelay(10*time.Millisecond, signals) || time.Since(started) < 10*time.Millisecond {
+		t.Error("expected the delay to be waited")
+	}
+
+	signals <- syscall.SIGTERM
+	if waitStartupDelay(time.Hour, signals) {
+		t.Error("expected a signal to interrupt the delay")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// statusConfig configures the json status endpoint
+type statusConfig struct {
+	// Addr is the address to serve /status on, empty disables the endpoint
+	Addr string
+}
+
+// statusTracker collects what is reported by the status endpoint
+type statusTracker struct {
+	started time.Time
+
+	mu             sync.Mutex
+	events         map[string]uint64
+	lastEvent      time.Time
+	lastWrite      time.Time
+	lastWriteError string
+	lastErrorAt    time.Time
+}
+
+var status = newStatusTracker(time.Now())
+
+func newStatusTracker(started time.Time) *statusTracker {
+	return &statusTracker{started: started, events: make(map[string]uint64)}
+}
+
+// event records an event of sensorType received at
+func (s *statusTracker) event(sensorType string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[sensorType]++
+	s.lastEvent = at
+}
+
+// write records the result of a write to influxdb
+func (s *statusTracker) write(err error, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastWriteError = err.Error()
+		s.lastErrorAt = at
+		return
+	}
+	s.lastWrite = at
+}
+
+// statusReport is the json served by the status endpoint
+type statusReport struct {
+	Version       string            `json:"version"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Connection    connectionStatus  `json:"connection"`
+	Events        map[string]uint64 `json:"events"`
+	LastEvent     *time.Time        `json:"last_event,omitempty"`
+	Influxdb2     sinkStatus        `json:"influxdb2"`
+	// Chattiest lists the sensors sending the most events
+	Chattiest []sensorRate `json:"chattiest,omitempty"`
+}
+
+type connectionStatus struct {
+	Connected bool       `json:"connected"`
+	Since     *time.Time `json:"since,omitempty"`
+}
+
+type sinkStatus struct {
+	PendingPoints int64      `json:"pending_points"`
+	LastWrite     *time.Time `json:"last_write,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+}
+
+// report returns the status as of now, connectedAt is zero while disconnected
+func (s *statusTracker) report(now, connectedAt time.Time) statusReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := statusReport{
+		Version:       version,
+		UptimeSeconds: now.Sub(s.started).Seconds(),
+		Connection:    connectionStatus{Connected: !connectedAt.IsZero(), Since: optionalTime(connectedAt)},
+		Events:        make(map[string]uint64, len(s.events)),
+		LastEvent:     optionalTime(s.lastEvent),
+		Influxdb2: sinkStatus{
+			PendingPoints: atomic.LoadInt64(&pendingPoints),
+			LastWrite:     optionalTime(s.lastWrite),
+			LastError:     s.lastWriteError,
+			LastErrorAt:   optionalTime(s.lastErrorAt),
+		},
+		Chattiest: chatty.top(),
+	}
+	for t, n := range s.events {
+		r.Events[t] = n
+	}
+	return r
+}
+
+// optionalTime returns nil for the zero time, so it is left out of the json
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ServeHTTP serves the status as json, only GET and HEAD are allowed
+func (s *statusTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.report(time.Now(), connection.since()))
+}
+
+// serveStatus starts serving the status on addr in its own goroutine
+func serveStatus(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/status", status)
+
+	go func() {
+		logging.Infof("Serving status on %s", addr)
+		err := http.ListenAndServe(addr, mux)
+		logging.Errorf("status endpoint stopped: %s", err)
+	}()
+}
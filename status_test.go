@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusReport(t *testing.T) {
+	started := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newStatusTracker(started)
+	s.event("ZHATemperature", started.Add(time.Minute))
+	s.event("ZHATemperature", started.Add(2*time.Minute))
+	s.event("ZHAHumidity", started.Add(3*time.Minute))
+	s.write(nil, started.Add(4*time.Minute))
+	s.write(errors.New("influxdb returned 500 Internal Server Error"), started.Add(5*time.Minute))
+
+	r := s.report(started.Add(10*time.Minute), started)
+	if r.UptimeSeconds != 600 || !r.Connection.Connected || !r.Connection.Since.Equal(started) {
+		t.Errorf("unexpected uptime or connection %+v", r)
+	}
+	if r.Events["ZHATemperature"] != 2 || r.Events["ZHAHumidity"] != 1 {
+		t.Errorf("unexpected events %v", r.Events)
+	}
+	if !r.LastEvent.Equal(started.Add(3 * time.Minute)) {
+		t.Errorf("unexpected last event %s", r.LastEvent)
+	}
+	if !r.Influxdb2.LastWrite.Equal(started.Add(4*time.Minute)) || r.Influxdb2.LastError == "" {
+		t.Errorf("unexpected sink status %+v", r.Influxdb2)
+	}
+
+	if r := s.report(started, time.Time{}); r.Connection.Connected || r.Connection.Since != nil {
+		t.Errorf("expected to be disconnected, got %+v", r.Connection)
+	}
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	s := newStatusTracker(time.Now())
+	s.event("ZHATemperature", time.Now())
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+
+	var r statusReport
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if r.Version != version || r.Events["ZHATemperature"] != 1 {
+		t.Errorf("unexpected status %+v", r)
+	}
+
+	resp, err = http.Post(server.URL+"/status", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected the endpoint to be read only, got %d", resp.StatusCode)
+	}
+}
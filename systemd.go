@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// readinessRetry is how often the connection to the gateway is checked until
+// it is established
+const readinessRetry = time.Second
+
+// systemdNotify notifies systemd about the state of deflux, it is a no-op
+// unless deflux was started by systemd with a notify socket
+type systemdNotify struct {
+	socket string
+}
+
+// newSystemdNotify returns a notifier for the socket in $NOTIFY_SOCKET
+func newSystemdNotify() *systemdNotify {
+	return &systemdNotify{socket: os.Getenv("NOTIFY_SOCKET")}
+}
+
+// notify sends state, e.g. READY=1, to systemd
+func (n *systemdNotify) notify(state string) error {
+	if n.socket == "" {
+		return nil
+	}
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+	// a leading @ is an abstract socket
+	if addr.Name[0] == '@' {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return fmt.Errorf("unable to notify systemd: %s", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return fmt.Errorf("unable to notify systemd: %s", err)
+	}
+	return nil
+}
+
+// ready notifies systemd once connected reports the gateway connected. Until
+// stop is closed the watchdog is then pinged while alive, so systemd restarts
+// a deflux that stopped receiving events
+func (n *systemdNotify) ready(connected func() bool, s *statusTracker, stop <-chan struct{}) {
+	if n.socket == "" {
+		return
+	}
+	for !connected() {
+		select {
+		case <-time.After(readinessRetry):
+		case <-stop:
+			return
+		}
+	}
+
+	err := n.notify("READY=1")
+	if err != nil {
+		logging.Warnf("%s", err)
+		return
+	}
+	logging.Infof("Notified systemd that deflux is ready")
+
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	readyAt := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			// the watchdog times out after twice the interval
+			if !alive(connected(), s, readyAt, now, 2*interval) {
+				continue
+			}
+			err = n.notify("WATCHDOG=1")
+			if err != nil {
+				logging.Warnf("%s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// alive reports if deflux is connected to the gateway and s received an event
+// within timeout of now, counting from since while none was received
+func alive(connected bool, s *statusTracker, since, now time.Time, timeout time.Duration) bool {
+	silence, silent := s.silentFor(timeout, since, now)
+	if !connected || silent {
+		logging.Debugf("not pinging the watchdog, connected %t and no events for %s", connected, silence.Round(time.Second))
+		return false
+	}
+	return true
+}
+
+// watchdogInterval returns how often to ping the watchdog of systemd, half
+// of $WATCHDOG_USEC, or zero if the watchdog is disabled
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAlive(t *testing.T) {
+	since := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newStatusTracker(since)
+	if !alive(true, s, since, since.Add(time.Second), time.Minute) {
+		t.Error("expected deflux to be alive right after getting ready")
+	}
+	if alive(false, s, since, since.Add(time.Second), time.Minute) {
+		t.Error("expected deflux not to be alive while disconnected")
+	}
+	if alive(true, s, since, since.Add(2*time.Minute), time.Minute) {
+		t.Error("expected deflux not to be alive without events for the timeout")
+	}
+	s.event("ZHATemperature", since.Add(90*time.Second))
+	if !alive(true, s, since, since.Add(2*time.Minute), time.Minute) {
+		t.Error("expected deflux to be alive after a recent event")
+	}
+}
+
+func TestSystemdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	os.Setenv("WATCHDOG_USEC", "20000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	if interval := watchdogInterval(); interval != 10*time.Millisecond {
+		t.Errorf("expected pinging the watchdog every 10ms, got %s", interval)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	// events keep arriving until quiet is closed
+	s := newStatusTracker(time.Now())
+	quiet := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-time.After(time.Millisecond):
+				s.event("ZHATemperature", time.Now())
+			case <-quiet:
+				return
+			}
+		}
+	}()
+	go (&systemdNotify{socket: socket}).ready(func() bool { return true }, s, stop)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, expected := range []string{"READY=1", "WATCHDOG=1", "WATCHDOG=1"} {
+		b := make([]byte, 64)
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		if string(b[:n]) != expected {
+			t.Errorf("expected %s, got %s", expected, b[:n])
+		}
+	}
+
+	// the watchdog is not pinged after the events stopped for its timeout
+	close(quiet)
+	time.Sleep(5 * time.Millisecond)
+	s.event("ZHATemperature", time.Now().Add(-time.Hour))
+	time.Sleep(20 * time.Millisecond)
+	drain := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	for {
+		if _, err := conn.Read(drain); err != nil {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := conn.Read(drain); err == nil {
+		t.Errorf("expected no ping without recent events, got %s", drain[:n])
+	}
+
+	// without a socket nothing is sent
+	if err := (&systemdNotify{}).notify("READY=1"); err != nil {
+		t.Errorf("expected notifying without systemd to do nothing, got %s", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dfuchslin/deflux/logging"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// tapConfig configures the websocket re-broadcasting the raw frames of deconz
+type tapConfig struct {
+	// Addr is the address to serve the websocket on, empty disables the tap
+	Addr string
+	// Buffer is the number of frames queued per client before frames are
+	// dropped for it, defaults to 256
+	Buffer int
+}
+
+// defaults of tapConfig
+const (
+	defaultTapBuffer    = 256
+	tapWriteTimeout     = 10 * time.Second
+	tapPingInterval     = 30 * time.Second
+	tapMaxClientMessage = 512
+)
+
+var (
+	tapClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "deflux_tap_clients",
+		Help: "Number of clients connected to the raw event websocket.",
+	})
+	tapDroppedFrames = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "deflux_tap_dropped_frames_total",
+		Help: "Frames not sent to a client of the raw event websocket as it did not keep up.",
+	})
+)
+
+// tap is nil unless the tap is configured, sensorEventChan hands it the
+// frames read from deconz
+var tap *rawTap
+
+// rawTap re-broadcasts raw frames to the connected websocket clients, frames
+// are queued per client so a slow client never blocks reading from deconz
+type rawTap struct {
+	buffer   int
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+func newRawTap(c tapConfig) *rawTap {
+	if c.Buffer <= 0 {
+		c.Buffer = defaultTapBuffer
+	}
+	return &rawTap{buffer: c.Buffer, clients: make(map[chan []byte]struct{})}
+}
+
+// broadcast queues frame for every client, it is dropped for clients whose
+// queue is full
+func (t *rawTap) broadcast(frame []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for frames := range t.clients {
+		select {
+		case frames <- frame:
+		default:
+			tapDroppedFrames.Inc()
+		}
+	}
+}
+
+func (t *rawTap) subscribe() chan []byte {
+	frames := make(chan []byte, t.buffer)
+	t.mu.Lock()
+	t.clients[frames] = struct{}{}
+	t.mu.Unlock()
+	tapClients.Inc()
+	return frames
+}
+
+func (t *rawTap) unsubscribe(frames chan []byte) {
+	t.mu.Lock()
+	delete(t.clients, frames)
+	t.mu.Unlock()
+	tapClients.Dec()
+}
+
+// ServeHTTP upgrades the request to a websocket and sends it every frame
+// read from deconz until the client disconnects
+func (t *rawTap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.Debugf("unable to upgrade tap client %s: %s", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	frames := t.subscribe()
+	defer t.unsubscribe(frames)
+	logging.Infof("Tap client %s connected", r.RemoteAddr)
+
+	// read until the client goes away, handling its control frames
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(tapMaxClientMessage)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(tapPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case frame := <-frames:
+			conn.SetWriteDeadline(time.Now().Add(tapWriteTimeout))
+			err = conn.WriteMessage(websocket.TextMessage, frame)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(tapWriteTimeout))
+		case <-closed:
+			logging.Infof("Tap client %s disconnected", r.RemoteAddr)
+			return
+		}
+		if err != nil {
+			logging.Infof("Tap client %s disconnected: %s", r.RemoteAddr, err)
+			return
+		}
+	}
+}
+
+// serveTap serves the websocket of t on addr
+func serveTap(addr string, t *rawTap) {
+	go func() {
+		logging.Infof("Serving raw events on ws://%s/", addr)
+		err := http.ListenAndServe(addr, t)
+		logging.Errorf("tap endpoint stopped: %s", err)
+	}()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// clientCount returns the number of clients subscribed to t
+func (t *rawTap) clientCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.clients)
+}
+
+func TestRawTap(t *testing.T) {
+	tap := newRawTap(tapConfig{})
+	server := httptest.NewServer(tap)
+	defer server.Close()
+
+	var clients []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+	for deadline := time.Now().Add(5 * time.Second); tap.clientCount() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 clients, got %d", tap.clientCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	frames := []string{`{"e":"changed","id":"1","r":"sensors"}`, `{"e":"changed","id":"2","r":"lights"}`}
+	for _, frame := range frames {
+		tap.broadcast([]byte(frame))
+	}
+
+	// every client receives every frame in order
+	for i, conn := range clients {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for _, expected := range frames {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				t.Logf(err.Error())
+				t.FailNow()
+			}
+			if string(frame) != expected {
+				t.Errorf("client %d: expected %s, got %s", i, expected, frame)
+			}
+		}
+	}
+
+	clients[0].Close()
+	for deadline := time.Now().Add(5 * time.Second); tap.clientCount() > 1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the closed client to be unsubscribed, got %d clients", tap.clientCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRawTapSlowClient(t *testing.T) {
+	tap := newRawTap(tapConfig{Buffer: 1})
+	frames := tap.subscribe()
+	defer tap.unsubscribe(frames)
+
+	// a full queue drops frames instead of blocking the reader
+	dropped := testutil.ToFloat64(tapDroppedFrames)
+	tap.broadcast([]byte("first"))
+	tap.broadcast([]byte("second"))
+	if n := testutil.ToFloat64(tapDroppedFrames) - dropped; n != 1 {
+		t.Errorf("expected 1 dropped frame, got %g", n)
+	}
+	if frame := <-frames; string(frame) != "first" {
+		t.Errorf("expected the first frame to be queued, got %s", frame)
+	}
+}
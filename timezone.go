@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+)
+
+// loadTimezone returns the timezone points are written in, nil for UTC
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "UTC" {
+		return nil, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// inTimezone returns ts as the wall clock time of location written as UTC,
+// for dashboards assuming local time. Unlike ts.In it does not keep the
+// instant, the result is off by the offset of location. A nil location
+// returns ts in UTC
+func inTimezone(ts time.Time, location *time.Location) time.Time {
+	if location == nil {
+		return ts.UTC()
+	}
+	local := ts.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInTimezone(t *testing.T) {
+	berlin, err := loadTimezone("Europe/Berlin")
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	utc, err := loadTimezone("UTC")
+	if err != nil || utc != nil {
+		t.Errorf("expected UTC to be the default, got %v, %v", utc, err)
+	}
+
+	ts := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		location *time.Location
+		expected time.Time
+	}{
+		{nil, ts},
+		{berlin, time.Date(2021, 7, 1, 14, 0, 0, 0, time.UTC)},
+	} {
+		actual := inTimezone(ts.In(time.FixedZone("elsewhere", -3600)), c.location)
+		if !actual.Equal(c.expected) || actual.Location() != time.UTC {
+			t.Errorf("%v: expected %s, got %s", c.location, c.expected, actual)
+		}
+	}
+
+	if _, err := loadTimezone("Mars/Olympus_Mons"); err == nil {
+		t.Error("expected an unknown timezone to be rejected")
+	}
+}
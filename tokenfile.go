@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/dfuchslin/deflux/logging"
+)
+
+// readToken returns the token held by the file name, surrounding whitespace
+// is trimmed
+func readToken(name string) (string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s holds no token", name)
+	}
+	return token, nil
+}
+
+// tokenTransport authorizes requests with the token read from a file, which
+// is read again when influxdb rejects it so a rotated token is picked up
+// without restarting
+type tokenTransport struct {
+	http.RoundTripper
+	file string
+
+	mu    sync.Mutex
+	token string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.current()
+	if err != nil {
+		return nil, fmt.Errorf("could not read influxdb token: %s", err)
+	}
+
+	resp, err := t.RoundTripper.RoundTrip(authorize(req, token))
+	if err != nil || !rejectedToken(resp.StatusCode) || req.GetBody == nil {
+		return resp, err
+	}
+
+	logging.Warnf("influxdb rejected the token with %s, reading %s again", resp.Status, t.file)
+	fresh, err := t.reload(token)
+	if err != nil {
+		logging.Errorf("could not read influxdb token: %s", err)
+		return resp, nil
+	}
+	if fresh == token {
+		return resp, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return resp, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	logging.Infof("retrying the write to influxdb with the token read from %s", t.file)
+	retry := authorize(req, fresh)
+	retry.Body = body
+	return t.RoundTripper.RoundTrip(retry)
+}
+
+// current returns the token, reading the file the first time
+func (t *tokenTransport) current() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" {
+		return t.token, nil
+	}
+	token, err := readToken(t.file)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	return token, nil
+}
+
+// reload reads the file again unless another request already replaced the
+// rejected token
+func (t *tokenTransport) reload(rejected string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != rejected {
+		return t.token, nil
+	}
+	token, err := readToken(t.file)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	return token, nil
+}
+
+// authorize returns a copy of req authorized with token, round trippers must
+// not modify the request they are given
+func authorize(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Token "+token)
+	return req
+}
+
+// rejectedToken reports if status is returned by influxdb for a token that is
+// invalid, or lacks access to the bucket
+func rejectedToken(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTokenTransport(t *testing.T) {
+	var mu sync.Mutex
+	valid := "Token first"
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "token")
+	write := func(token string) {
+		err := ioutil.WriteFile(file, []byte(token+"\n"), 0600)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+	}
+	write("first")
+	transport := &tokenTransport{RoundTripper: http.DefaultTransport, file: file}
+	client := http.Client{Transport: transport}
+	do := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v2/write", strings.NewReader(body))
+		req.Header.Set("Authorization", "Token configured")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Logf(err.Error())
+			t.FailNow()
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := do("m v=1\n"); status != http.StatusNoContent {
+		t.Errorf("expected the token from the file to be accepted, got %d", status)
+	}
+
+	// rotate the token, the transport still holds the first one
+	write("second")
+	mu.Lock()
+	valid = "Token second"
+	mu.Unlock()
+	if status := do("m v=2\n"); status != http.StatusNoContent {
+		t.Errorf("expected the write to be retried with the rotated token, got %d", status)
+	}
+
+	mu.Lock()
+	valid = "Token third"
+	mu.Unlock()
+	if status := do("m v=3\n"); status != http.StatusUnauthorized {
+		t.Errorf("expected a rejected token to be returned, got %d", status)
+	}
+
+	if len(bodies) != 2 || bodies[1] != "m v=2\n" {
+		t.Errorf("unexpected bodies written %q", bodies)
+	}
+}
+
+func TestReadToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if _, err := readToken(file); err == nil {
+		t.Error("expected an error on a missing file")
+	}
+	ioutil.WriteFile(file, []byte(" \n"), 0600)
+	if _, err := readToken(file); err == nil {
+		t.Error("expected an error on an empty file")
+	}
+	ioutil.WriteFile(file, []byte("secret\n"), 0600)
+	if token, err := readToken(file); err != nil || token != "secret" {
+		t.Errorf("unexpected token %q: %v", token, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+)
+
+// watchCommand prints a line per event received from the configured gateway
+// until interrupted, nothing is written to influxdb
+func watchCommand(args []string) error {
+	config, err := loadFlagConfiguration()
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sensorChan, reader, err := sensorEventChan(config.Deconz, nil)
+	if err != nil {
+		return fmt.Errorf("could not connect to deconz: %s", err)
+	}
+	defer reader.StopReadEvents()
+
+	fmt.Fprintf(os.Stdout, "%-8s  %-24s  %-20s  %s\n", "TIME", "NAME", "TYPE", "FIELDS")
+	for {
+		select {
+		case e := <-sensorChan:
+			writeWatchLine(os.Stdout, e, time.Now())
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+// writeWatchLine writes the time, name, type and fields of e as a single line
+func writeWatchLine(w io.Writer, e *deconz.SensorEvent, at time.Time) {
+	fmt.Fprintf(w, "%-8s  %-24s  %-20s  %s\n", at.Format("15:04:05"), truncateName(e.Sensor.Name, 24), e.Sensor.Type, watchFields(e))
+}
+
+// watchFields formats the fields of e sorted by name, or the reason it has none
+func watchFields(e *deconz.SensorEvent) string {
+	_, fields, err := e.Timeseries()
+	if err != nil {
+		return "-"
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	formatted := make([]string, 0, len(names))
+	for _, name := range names {
+		formatted = append(formatted, fmt.Sprintf("%s=%v", name, fields[name]))
+	}
+	return strings.Join(formatted, " ")
+}
+
+// truncateName shortens name to max runes, marking it as shortened
+func truncateName(name string, max int) string {
+	r := []rune(name)
+	if len(r) <= max {
+		return name
+	}
+	return string(r[:max-1]) + "…"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dfuchslin/deflux/deconz"
+	"github.com/dfuchslin/deflux/deconz/event"
+)
+
+func TestWriteWatchLine(t *testing.T) {
+	d := event.Decoder{TypeStore: temperatureLookup{}}
+	e, err := d.Parse([]byte(gatewayTemperatureEvent))
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+
+	var b bytes.Buffer
+	writeWatchLine(&b, &deconz.SensorEvent{Event: e, Sensor: &deconz.Sensor{Name: "Kitchen", Type: "ZHATemperature"}}, time.Date(2021, 3, 1, 12, 30, 15, 0, time.UTC))
+
+	expected := "12:30:15  Kitchen                   ZHATemperature        temperature=22\n"
+	if b.String() != expected {
+		t.Errorf("unexpected line %q, expected %q", b.String(), expected)
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	if name := truncateName("Kitchen", 24); name != "Kitchen" {
+		t.Errorf("unexpected name %s", name)
+	}
+	if name := truncateName("Living room window sensor", 10); name != "Living ro…" {
+		t.Errorf("unexpected truncated name %s", name)
+	}
+}